@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// deletedCollection keeps a copy of every deleted task when audit_deletes is
+// set. Entries are only ever added to it, recovering a task leaves its entry
+// in place.
+const deletedCollection = "deleted_tasks"
+
+// deletedTask is an entry of the deleted_tasks collection
+type deletedTask struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	Task       *Task              `bson:"task" json:"task"`
+	Collection string             `bson:"collection" json:"collection"`
+	DeletedAt  time.Time          `bson:"deleted_at" json:"deleted_at"`
+	DeletedBy  string             `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+}
+
+// deleteTasks deletes the tasks matching filter, only the first of them
+// unless many is set, and returns how many were deleted. With audit_deletes
+// each task is copied to deleted_tasks first, and only the tasks that were
+// copied are deleted.
+func deleteTasks(filter bson.D, many bool) (int64, error) {
+	if !config.AuditDeletes {
+		if many {
+			res, err := collection.DeleteMany(ctx, filter)
+			if err != nil {
+				return 0, err
+			}
+
+			return res.DeletedCount, nil
+		}
+
+		res, err := collection.DeleteOne(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+
+		return res.DeletedCount, nil
+	}
+
+	var opts []*options.FindOptions
+	if !many {
+		opts = append(opts, options.Find().SetLimit(1))
+	}
+
+	tasks, err := filterTasks(filter, opts...)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	// the name is only informative, so not knowing it doesn't stop the
+	// deletion
+	user, _ := currentUser()
+
+	entries := make([]interface{}, len(tasks))
+	ids := make(bson.A, len(tasks))
+	for i, t := range tasks {
+		entries[i] = &deletedTask{
+			ID:         primitive.NewObjectID(),
+			Task:       t,
+			Collection: collection.Name(),
+			DeletedAt:  now(),
+			DeletedBy:  user,
+		}
+		ids[i] = t.ID
+	}
+
+	_, err = database.Collection(deletedCollection).InsertMany(ctx, entries)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot record the deletion, nothing was deleted: %v", err)
+	}
+
+	res, err := collection.DeleteMany(ctx, bson.D{primitive.E{Key: "_id", Value: bson.D{
+		primitive.E{Key: "$in", Value: ids},
+	}}})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
+
+// listDeleted returns the deleted tasks of the current collection, most
+// recently deleted first
+func listDeleted() ([]*deletedTask, error) {
+	filter := bson.D{primitive.E{Key: "collection", Value: collection.Name()}}
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "deleted_at", Value: -1}})
+
+	cur, err := database.Collection(deletedCollection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*deletedTask{}
+	err = cur.All(ctx, &list)
+	return list, err
+}
+
+// recoverDeleted adds the most recently deleted task matching ref, given as
+// an id, a short id or the exact text of the task, back to the current
+// collection
+func recoverDeleted(ref string) (*Task, error) {
+	if ref == "" {
+		return nil, errors.New("No task specified")
+	}
+
+	list, err := listDeleted()
+	if err != nil {
+		return nil, err
+	}
+
+	var match *deletedTask
+	for _, d := range list {
+		id := d.Task.ID.Hex()
+		if id == ref || (isShortID(ref) && strings.HasSuffix(id, strings.ToLower(ref))) || d.Task.Text == ref {
+			match = d
+			break
+		}
+	}
+
+	if match == nil {
+		return nil, &noMatchError{fmt.Sprintf("No deleted task matches '%s'", ref)}
+	}
+
+	_, err = collection.InsertOne(ctx, match.Task)
+	if isDuplicateKey(err) {
+		return nil, fmt.Errorf("'%s' has already been recovered", match.Task.Text)
+	}
+
+	return match.Task, err
+}
+
+func printDeleted(list []*deletedTask) {
+	if jsonOutput {
+		printJSON(list)
+		return
+	}
+
+	if len(list) == 0 {
+		if !quiet {
+			fmt.Println("No deleted tasks have been recorded")
+		}
+		return
+	}
+
+	for _, d := range list {
+		by := ""
+		if d.DeletedBy != "" {
+			by = " by " + d.DeletedBy
+		}
+
+		fmt.Printf("%s %s (deleted %s%s)\n", shortID(d.Task.ID, defaultIDLen), d.Task.Text, d.DeletedAt.Local().Format("2006-01-02 15:04"), by)
+	}
+}
+
+// hiddenTask is a deleted task as exported by export --include-hidden
+type hiddenTask struct {
+	*Task
+	Deleted   bool      `json:"deleted"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+}
+
+// listHidden returns the deleted tasks of the current collection that
+// aren't among tasks, the most recent deletion of each. Tasks that have been
+// recovered are then only exported once.
+func listHidden(tasks []*Task) ([]*hiddenTask, error) {
+	list, err := listDeleted()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[primitive.ObjectID]bool, len(tasks)+len(list))
+	for _, t := range tasks {
+		seen[t.ID] = true
+	}
+
+	hidden := []*hiddenTask{}
+	for _, d := range list {
+		if seen[d.Task.ID] {
+			continue
+		}
+
+		seen[d.Task.ID] = true
+		hidden = append(hidden, &hiddenTask{
+			Task:      d.Task,
+			Deleted:   true,
+			DeletedAt: d.DeletedAt,
+			DeletedBy: d.DeletedBy,
+		})
+	}
+
+	return hidden, nil
+}
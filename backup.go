@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// backupVersion is bumped whenever the backup format changes in a way that
+// older versions of restore cannot read
+const backupVersion = 1
+
+// backup is a self-describing dump of a whole task collection
+type backup struct {
+	Version    int       `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+	Database   string    `json:"database"`
+	Collection string    `json:"collection"`
+	Tasks      []*Task   `json:"tasks"`
+}
+
+func writeBackup(path string) (int, error) {
+	tasks, err := filterTasks(bson.D{})
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+
+	b := &backup{
+		Version:    backupVersion,
+		CreatedAt:  now(),
+		Database:   database.Name(),
+		Collection: collection.Name(),
+		Tasks:      tasks,
+	}
+
+	if b.Tasks == nil {
+		b.Tasks = []*Task{}
+	}
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b.Tasks), ioutil.WriteFile(path, data, 0600)
+}
+
+func readBackup(path string) (*backup, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &backup{}
+	err = json.Unmarshal(data, b)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a tasker backup: %v", path, err)
+	}
+
+	if b.Version < 1 || b.Version > backupVersion {
+		return nil, fmt.Errorf("%s has backup version %d, this version of tasker can only restore version %d",
+			path, b.Version, backupVersion)
+	}
+
+	return b, nil
+}
+
+// existingTasks returns how many of the backed up tasks are still present
+// in the collection and would be overwritten by a restore
+func existingTasks(b *backup) (int64, error) {
+	ids := make([]primitive.ObjectID, len(b.Tasks))
+	for i, t := range b.Tasks {
+		ids[i] = t.ID
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: bson.D{
+		primitive.E{Key: "$in", Value: ids},
+	}}}
+
+	return collection.CountDocuments(ctx, filter)
+}
+
+// restoreBackup writes every task in the backup to the collection, replacing
+// tasks that have the same id
+func restoreBackup(b *backup) (int64, error) {
+	if len(b.Tasks) == 0 {
+		return 0, nil
+	}
+
+	err := restoreSeqs(b)
+	if err != nil {
+		return 0, err
+	}
+
+	models := make([]mongo.WriteModel, len(b.Tasks))
+	for i, t := range b.Tasks {
+		models[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.D{primitive.E{Key: "_id", Value: t.ID}}).
+			SetReplacement(t).
+			SetUpsert(true)
+	}
+
+	res, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, err
+	}
+
+	// matched tasks were replaced, even if identical to the backed up copy
+	return res.UpsertedCount + res.MatchedCount, nil
+}
+
+// restoreSeqs keeps the numbers of the backed up tasks, except where another
+// task of the collection has taken the number since, and moves the counter
+// past them so that adds after the restore get new numbers. Tasks that lose
+// their number, or never had one, are numbered anew.
+func restoreSeqs(b *backup) error {
+	err := ensureSeqIndex(collection)
+	if err != nil {
+		logWarn("cannot create the index on seq", "collection", collection.Name(), "error", err.Error())
+	}
+
+	ids := bson.A{}
+	seqs := bson.A{}
+	var max int64
+	for _, t := range b.Tasks {
+		ids = append(ids, t.ID)
+		if t.Seq > 0 {
+			seqs = append(seqs, t.Seq)
+		}
+		if t.Seq > max {
+			max = t.Seq
+		}
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$nin", Value: ids}}},
+		primitive.E{Key: "seq", Value: bson.D{primitive.E{Key: "$in", Value: seqs}}},
+	}
+
+	taken := make(map[int64]bool)
+	others, err := filterTasks(filter, options.Find().SetProjection(bson.D{primitive.E{Key: "seq", Value: 1}}))
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	for _, t := range others {
+		taken[t.Seq] = true
+	}
+
+	err = raiseSeq(collection, max)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range b.Tasks {
+		if t.Seq > 0 && !taken[t.Seq] {
+			// a backup can't hand out the same number twice either
+			taken[t.Seq] = true
+			continue
+		}
+
+		t.Seq, err = nextSeq()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Config holds the settings read from the config file, a JSON object stored
+// at $TASKER_CONFIG or tasker/config.json in the user's config directory.
+// Flags given on the command line take precedence over it.
+type Config struct {
+	// WIPLimit is the maximum number of pending tasks, zero means unlimited
+	WIPLimit int `json:"wip_limit"`
+
+	// IDLen is the minimum number of characters of short ids in listings
+	IDLen *int `json:"id_len"`
+
+	// Sort maps a listing (pending, all or finished) to its default sort
+	// order, in the same format as the --sort flag
+	Sort map[string]string `json:"sort"`
+
+	// NotifyCommand is run by check-reminders for every task that has become
+	// overdue, with the text of the task as its last argument
+	NotifyCommand string `json:"notify_command"`
+
+	// CollectionPerDay keeps the tasks of each day in a collection of its
+	// own, see --collection-per-day
+	CollectionPerDay bool `json:"collection_per_day"`
+
+	// Bell rings the terminal bell when done completes a task
+	Bell bool `json:"bell"`
+
+	// CompleteSoundCommand is run instead of ringing the bell, e.g. to play
+	// a sound file
+	CompleteSoundCommand string `json:"complete_sound_command"`
+
+	// Retention is how long completed tasks are kept, e.g. 30d. Older ones
+	// are purged once a day. Empty keeps them forever.
+	Retention string `json:"retention"`
+
+	// AuditDeletes copies every deleted task to the deleted_tasks
+	// collection, from where recover-deleted can add it back
+	AuditDeletes bool `json:"audit_deletes"`
+
+	// ConfirmDestructive asks before every command that deletes or
+	// overwrites tasks, see --confirm-destructive
+	ConfirmDestructive bool `json:"confirm_destructive"`
+
+	// Lenient lets done and rm succeed when there is nothing to do
+	Lenient bool `json:"lenient"`
+
+	// TagColors colors pending tasks by their first tag
+	TagColors bool `json:"tag_colors"`
+
+	// ColorScheme is the --color-scheme used unless the flag is given
+	ColorScheme string `json:"color_scheme"`
+
+	// StoreUTC stores timestamps in UTC rather than the local time zone
+	StoreUTC bool `json:"store_utc"`
+
+	// TimeZone is the IANA name of the zone times are shown and entered in,
+	// the system's zone by default
+	TimeZone string `json:"time_zone"`
+
+	// Profiles are named setups selected with --profile, e.g. work and home
+	Profiles map[string]*Profile `json:"profiles"`
+}
+
+var config Config
+
+// Profile is a named setup with a database of its own. Any of the other
+// config settings can be given in a profile too, where they take precedence
+// over the top level ones.
+type Profile struct {
+	URI        string `json:"uri"`
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+
+	Config
+}
+
+// profile is the profile selected with --profile, empty if none is
+var profile Profile
+
+// useProfile selects the named profile and merges its settings into config
+func useProfile(name string) error {
+	p, ok := config.Profiles[name]
+	if !ok || p == nil {
+		names := profileNames()
+		if len(names) == 0 {
+			return fmt.Errorf("Unknown profile %q, no profiles are configured", name)
+		}
+
+		return fmt.Errorf("Unknown profile %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+
+	profile = *p
+
+	if p.WIPLimit != 0 {
+		config.WIPLimit = p.WIPLimit
+	}
+	if p.IDLen != nil {
+		config.IDLen = p.IDLen
+	}
+	for listing, spec := range p.Sort {
+		if config.Sort == nil {
+			config.Sort = make(map[string]string)
+		}
+
+		config.Sort[listing] = spec
+	}
+	if p.NotifyCommand != "" {
+		config.NotifyCommand = p.NotifyCommand
+	}
+	if p.CollectionPerDay {
+		config.CollectionPerDay = true
+	}
+	if p.Bell {
+		config.Bell = true
+	}
+	if p.CompleteSoundCommand != "" {
+		config.CompleteSoundCommand = p.CompleteSoundCommand
+	}
+	if p.Retention != "" {
+		config.Retention = p.Retention
+	}
+	if p.AuditDeletes {
+		config.AuditDeletes = true
+	}
+	if p.ConfirmDestructive {
+		config.ConfirmDestructive = true
+	}
+	if p.Lenient {
+		config.Lenient = true
+	}
+	if p.TagColors {
+		config.TagColors = true
+	}
+	if p.ColorScheme != "" {
+		config.ColorScheme = p.ColorScheme
+	}
+	if p.StoreUTC {
+		config.StoreUTC = true
+	}
+	if p.TimeZone != "" {
+		config.TimeZone = p.TimeZone
+	}
+
+	return nil
+}
+
+func profileNames() []string {
+	var names []string
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// setting returns the value of the string flag name, or fallback if the flag
+// isn't given and fallback isn't empty
+func setting(c *cli.Context, name, fallback string) string {
+	if c.IsSet(name) || fallback == "" {
+		return c.String(name)
+	}
+
+	return fallback
+}
+
+func configPath() (string, error) {
+	if path := os.Getenv("TASKER_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "tasker", "config.json"), nil
+}
+
+// loadConfig reads the config file into config. A missing file is not an
+// error, the defaults are used instead.
+func loadConfig() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return fmt.Errorf("Invalid config file %s: %v", path, err)
+	}
+
+	return nil
+}
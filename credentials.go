@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// setPassword supplies the password for a connection string that names a
+// user but leaves the password out. It is read from path if given, or else
+// asked for on the terminal when interactive is set. The password is only
+// ever handed to the driver, so it never shows up in the URI we log or
+// print.
+func setPassword(opts *options.ClientOptions, path string, interactive bool) error {
+	if opts.Auth == nil || opts.Auth.Username == "" {
+		if path != "" {
+			return errors.New("--password-file needs a user name in the connection string, e.g. mongodb://user@host/")
+		}
+
+		return nil
+	}
+
+	if opts.Auth.PasswordSet && opts.Auth.Password != "" {
+		if path != "" {
+			return errors.New("The connection string already contains a password, drop it to use --password-file")
+		}
+
+		return nil
+	}
+
+	var password string
+	switch {
+	case path != "":
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Cannot read the password file: %v", err)
+		}
+
+		// editors tend to add a final newline
+		password = strings.TrimRight(string(b), "\r\n")
+	case interactive && isTerminal(os.Stdin):
+		var err error
+		password, err = readPassword(fmt.Sprintf("Password for %s: ", opts.Auth.Username))
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	opts.Auth.Password = password
+	opts.Auth.PasswordSet = true
+	return nil
+}
+
+// readPassword asks for a password on the terminal without echoing it. Echo
+// is turned off with stty, where that isn't available the password shows.
+func readPassword(question string) (string, error) {
+	fmt.Fprint(os.Stderr, question)
+
+	if stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func stty(setting string) error {
+	cmd := exec.Command("stty", setting)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
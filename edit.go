@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const editHeader = `# Edit your pending tasks, one per line. Change the text after an id to
+# rename that task, delete a line to delete the task, or add a line without
+# an id to add a new task. Lines starting with # are ignored.
+`
+
+// editChanges holds what an edited task list asks to change
+type editChanges struct {
+	renamed map[primitive.ObjectID]string
+	deleted []primitive.ObjectID
+	added   []string
+}
+
+// editAll writes the pending tasks to a temporary file, opens it in the
+// user's editor and applies the edits once the editor exits. Nothing is
+// changed if any line of the edited file cannot be understood. With
+// askDeletes the tasks whose lines were deleted are only deleted once the
+// user confirms.
+func editAll(askDeletes bool) error {
+	tasks, err := getPending(nil, pendingSort)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	f, err := ioutil.TempFile("", "tasker-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	var buf bytes.Buffer
+	buf.WriteString(editHeader)
+	for _, t := range tasks {
+		fmt.Fprintf(&buf, "%s %s\n", t.ID.Hex(), t.Text)
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = runEditor(f.Name())
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+
+	changes, err := parseEdit(b, tasks)
+	if err != nil {
+		return fmt.Errorf("%v, no changes were made", err)
+	}
+
+	if askDeletes && len(changes.deleted) > 0 {
+		ok, err := confirm(fmt.Sprintf("Delete %s? This cannot be undone", plural(int64(len(changes.deleted)), "task")))
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return errors.New("Edit cancelled, no changes were made")
+		}
+	}
+
+	return applyEdit(changes)
+}
+
+// runEditor opens path in $VISUAL or $EDITOR, falling back to vi
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// the editor may come with arguments, e.g. "code --wait"
+	args := append(strings.Fields(editor), path)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Editor %s failed: %v", args[0], err)
+	}
+
+	return nil
+}
+
+// parseEdit compares the edited list b with the tasks it was written from
+func parseEdit(b []byte, tasks []*Task) (*editChanges, error) {
+	original := make(map[primitive.ObjectID]*Task, len(tasks))
+	for _, t := range tasks {
+		original[t.ID] = t
+	}
+
+	changes := &editChanges{renamed: make(map[primitive.ObjectID]string)}
+	seen := make(map[primitive.ObjectID]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		id, err := primitive.ObjectIDFromHex(fields[0])
+		if err != nil {
+			changes.added = append(changes.added, line)
+			continue
+		}
+
+		t, ok := original[id]
+		if !ok {
+			return nil, fmt.Errorf("Line %d: unknown task id %s", n, fields[0])
+		}
+
+		if seen[id] {
+			return nil, fmt.Errorf("Line %d: task %s is listed more than once", n, fields[0])
+		}
+		seen[id] = true
+
+		text := ""
+		if len(fields) == 2 {
+			text = strings.TrimSpace(fields[1])
+		}
+
+		if text == "" {
+			return nil, fmt.Errorf("Line %d: task %s has no text, delete the line to delete the task", n, fields[0])
+		}
+
+		if text != t.Text {
+			changes.renamed[id] = text
+		}
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// like git rebase, an emptied file aborts rather than deleting everything
+	if len(tasks) > 0 && len(seen) == 0 && len(changes.added) == 0 {
+		return nil, errors.New("The task list is empty")
+	}
+
+	for _, t := range tasks {
+		if !seen[t.ID] {
+			changes.deleted = append(changes.deleted, t.ID)
+		}
+	}
+
+	return changes, nil
+}
+
+func applyEdit(changes *editChanges) error {
+	if len(changes.renamed)+len(changes.deleted)+len(changes.added) == 0 {
+		if !quiet {
+			fmt.Println("No changes")
+		}
+
+		return nil
+	}
+
+	for id, text := range changes.renamed {
+		_, err := updateTask(id, bson.D{primitive.E{Key: "text", Value: text}})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range changes.deleted {
+		err := deleteTaskByID(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, text := range changes.added {
+		err := createTask(newTask(text))
+		if err != nil {
+			return err
+		}
+	}
+
+	if !quiet {
+		fmt.Printf("%d renamed, %d deleted, %d added\n", len(changes.renamed), len(changes.deleted), len(changes.added))
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// loadEnvFile sets the TASKER_* variables defined in a dotenv file. Other
+// variables in the file are ignored, and variables already present in the
+// environment take precedence over the file.
+//
+// Lines have the form KEY=value, optionally prefixed with "export". Values
+// may be wrapped in single or double quotes, and lines starting with # are
+// comments.
+func loadEnvFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Env file %s does not exist", path)
+		}
+
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		i := strings.Index(line, "=")
+		if i < 1 {
+			return fmt.Errorf("%s:%d: expected KEY=value", path, n)
+		}
+
+		key := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		if !strings.HasPrefix(key, "TASKER_") {
+			continue
+		}
+
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+
+		err = os.Setenv(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// applyEnv sets the global flags that weren't given explicitly from their
+// environment variables. urfave/cli reads the environment while parsing the
+// global flags, which is before an env file has been loaded.
+func applyEnv(c *cli.Context) error {
+	for _, f := range c.App.Flags {
+		var envVars []string
+		switch f := f.(type) {
+		case *cli.StringFlag:
+			envVars = f.EnvVars
+		case *cli.BoolFlag:
+			envVars = f.EnvVars
+		case *cli.IntFlag:
+			envVars = f.EnvVars
+		}
+
+		name := f.Names()[0]
+		if c.IsSet(name) {
+			continue
+		}
+
+		for _, env := range envVars {
+			if v, ok := os.LookupEnv(env); ok {
+				err := c.Set(name, v)
+				if err != nil {
+					return fmt.Errorf("Invalid value %q for %s: %v", v, env, err)
+				}
+
+				break
+			}
+		}
+	}
+
+	return nil
+}
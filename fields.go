@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// taskFields are the fields --fields can select. The id is always included.
+var taskFields = []string{"text", "completed", "created_at", "updated_at", "completed_at", "due_date", "assignee", "project", "tags", "pinned", "order", "priority", "link", "time_spent", "status", "seq"}
+
+// fieldAliases are shorter names accepted by --fields
+var fieldAliases = map[string]string{
+	"due":     "due_date",
+	"created": "created_at",
+	"updated": "updated_at",
+}
+
+// shownFields are the fields a listing fetches and shows, nil for all of
+// them. Fields left out are shown as if they weren't set.
+var shownFields []string
+
+// withIDs ends each line of the text listing with the full id of the task
+var withIDs bool
+
+// limitText is the number of characters of each task's text shown by
+// listings meant for people, zero for no limit
+var limitText int
+
+// expandEnv shows ${VAR} references in task texts with the values of the
+// environment variables, see displayText
+var expandEnv bool
+
+// textOnly prints nothing but the text of each task, for feeding listings
+// to other tools
+var textOnly bool
+
+// parseFields parses a comma separated list of field names, returning nil
+// if the list is empty
+func parseFields(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	var list []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if alias, ok := fieldAliases[name]; ok {
+			name = alias
+		}
+
+		if name == "id" || name == "_id" || contains(list, name) {
+			continue
+		}
+
+		if !contains(taskFields, name) {
+			return nil, fmt.Errorf("Unknown field '%s', expected one of %s", name, strings.Join(taskFields, ", "))
+		}
+
+		list = append(list, name)
+	}
+
+	return list, nil
+}
+
+// fieldProjection returns the projection fetching only shownFields, or nil
+// to fetch whole tasks
+func fieldProjection() bson.D {
+	if shownFields == nil {
+		return nil
+	}
+
+	projection := bson.D{primitive.E{Key: "_id", Value: 1}}
+	for _, name := range shownFields {
+		projection = append(projection, primitive.E{Key: name, Value: 1})
+	}
+
+	return projection
+}
+
+// selectFields returns tasks as JSON objects holding only the id and
+// shownFields
+func selectFields(tasks []*Task) ([]map[string]interface{}, error) {
+	list := make([]map[string]interface{}, 0, len(tasks))
+
+	for _, t := range tasks {
+		b, err := json.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+
+		var all map[string]interface{}
+		err = json.Unmarshal(b, &all)
+		if err != nil {
+			return nil, err
+		}
+
+		obj := map[string]interface{}{"id": all["id"]}
+		for _, name := range shownFields {
+			if v, ok := all[name]; ok {
+				obj[name] = v
+			}
+		}
+
+		list = append(list, obj)
+	}
+
+	return list, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// defaultFocus is the length of a focus session, one pomodoro
+const defaultFocus = 25 * time.Minute
+
+// focus marks t as in progress and counts down d. When the time is up the
+// user is asked whether the task is done. Interrupting the countdown cancels
+// the session and puts the task back the way it was.
+func focus(t *Task, d time.Duration) error {
+	err := setStatus(t.ID, statusDoing)
+	if err != nil {
+		return err
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	end := time.Now().Add(d)
+	for left := d; left > 0; left = time.Until(end) {
+		fmt.Printf("\r%s left on '%s' ", formatCountdown(left), t.Text)
+
+		select {
+		case <-ticker.C:
+		case <-interrupt:
+			fmt.Println()
+
+			err := setStatus(t.ID, t.Status)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("Focus session cancelled")
+			return nil
+		}
+	}
+
+	fmt.Printf("\r%s left on '%s'\n", formatCountdown(0), t.Text)
+	// signal the end of the session
+	fmt.Print("\a")
+
+	done, err := confirm(fmt.Sprintf("Time's up! Is '%s' done?", t.Text))
+	if err != nil {
+		return err
+	}
+
+	if !done {
+		fmt.Printf("'%s' is still in progress\n", t.Text)
+		return nil
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: t.ID}}
+	_, err = completeTask(filter, now(), 0)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Completed '%s'\n", t.Text)
+	return nil
+}
+
+// formatCountdown formats the time left as mm:ss
+func formatCountdown(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// setStatus sets the status of the task with the given id, an empty status
+// removes it
+func setStatus(id primitive.ObjectID, status string) error {
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+
+	update := bson.D{primitive.E{Key: "$set", Value: bson.D{
+		primitive.E{Key: "status", Value: status},
+		primitive.E{Key: "updated_at", Value: now()},
+	}}}
+	if status == "" {
+		update = bson.D{
+			primitive.E{Key: "$unset", Value: bson.D{primitive.E{Key: "status", Value: ""}}},
+			primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: now()}}},
+		}
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
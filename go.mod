@@ -1,6 +1,6 @@
 module github.com/ayoisaiah/tasker
 
-go 1.14
+go 1.16
 
 require (
 	github.com/urfave/cli/v2 v2.2.0 // indirect
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// importedTask is a task read by import, in the form export prints it
+type importedTask struct {
+	*Task
+
+	// Deleted marks the deleted tasks printed by export --include-hidden,
+	// which are left out of an import
+	Deleted bool `json:"deleted"`
+}
+
+// readImport reads the tasks of a file written by export, either as a JSON
+// array or with --format ndjson
+func readImport(path string) ([]*Task, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*importedTask
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		err = json.Unmarshal(data, &list)
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			it := &importedTask{}
+			err = dec.Decode(it)
+			if err == io.EOF {
+				err = nil
+				break
+			}
+			if err != nil {
+				break
+			}
+
+			list = append(list, it)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a tasker export: %v", path, err)
+	}
+
+	var tasks []*Task
+	for i, it := range list {
+		if it.Deleted {
+			continue
+		}
+
+		if it.Task == nil || it.Text == "" {
+			return nil, fmt.Errorf("Task %d of %s has no text", i+1, path)
+		}
+
+		tasks = append(tasks, it.Task)
+	}
+
+	return tasks, nil
+}
+
+// importTasks adds the imported tasks to the collection as new tasks. With
+// mergeByText a task replaces the oldest existing task with the same text
+// instead, which keeps its id, number, creation time and place in the list.
+// It returns how many tasks were added and how many were replaced.
+func importTasks(tasks []*Task, mergeByText bool) (int64, int64, error) {
+	var added, replaced int64
+
+	for _, t := range tasks {
+		if mergeByText {
+			existing := &Task{}
+			filter := bson.D{primitive.E{Key: "text", Value: t.Text}}
+			opts := options.FindOne().SetSort(bson.D{primitive.E{Key: "created_at", Value: 1}})
+
+			err := collection.FindOne(ctx, filter, opts).Decode(existing)
+			if err != nil && err != mongo.ErrNoDocuments {
+				return added, replaced, err
+			}
+
+			if err == nil {
+				t.ID = existing.ID
+				t.Seq = existing.Seq
+				t.CreatedAt = existing.CreatedAt
+				t.Order = existing.Order
+				t.UpdatedAt = now()
+				if t.Tags == nil {
+					t.Tags = []string{}
+				}
+
+				_, err = collection.ReplaceOne(ctx, bson.D{primitive.E{Key: "_id", Value: t.ID}}, t)
+				if err != nil {
+					return added, replaced, err
+				}
+
+				replaced++
+				continue
+			}
+		}
+
+		// the imported id, number and times belong to wherever the task
+		// was exported from
+		n := newTask(t.Text)
+		n.Completed = t.Completed
+		n.CompletedAt = t.CompletedAt
+		n.DueDate = t.DueDate
+		n.Assignee = t.Assignee
+		n.Project = t.Project
+		n.Pinned = t.Pinned
+		n.Priority = t.Priority
+		n.Link = t.Link
+		n.TimeSpent = t.TimeSpent
+		n.Status = t.Status
+		if t.Tags != nil {
+			n.Tags = t.Tags
+		}
+
+		err := createTask(n)
+		if err != nil {
+			return added, replaced, err
+		}
+
+		added++
+	}
+
+	return added, replaced, nil
+}
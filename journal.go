@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionPerDay writes tasks into a collection for each day, named after
+// the --collection with the date appended, e.g. tasks_2024_05_01
+var collectionPerDay bool
+
+const journalLayout = "2006_01_02"
+
+// journalCollection returns the name of the collection holding the tasks
+// of day
+func journalCollection(base string, day time.Time) string {
+	return base + "_" + day.Format(journalLayout)
+}
+
+// journalDay is the list of tasks of one day of the journal
+type journalDay struct {
+	Date  string  `json:"date"`
+	Tasks []*Task `json:"tasks"`
+}
+
+// journal returns the tasks of each day from from to to, inclusive, that
+// has a collection of its own. Days are compared by date only.
+func journal(base string, from, to time.Time) ([]*journalDay, error) {
+	pattern := "^" + regexp.QuoteMeta(base) + `_\d{4}_\d{2}_\d{2}$`
+	filter := bson.D{primitive.E{Key: "name", Value: primitive.Regex{Pattern: pattern}}}
+
+	names, err := database.ListCollectionNames(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	first := journalCollection(base, from)
+	last := journalCollection(base, to)
+
+	// the date format sorts in chronological order
+	sort.Strings(names)
+
+	var days []*journalDay
+	for _, name := range names {
+		if name < first || name > last {
+			continue
+		}
+
+		day, err := time.ParseInLocation(journalLayout, name[len(base)+1:], time.Local)
+		if err != nil {
+			continue
+		}
+
+		opts := options.Find().SetSort(bson.D{primitive.E{Key: "created_at", Value: 1}})
+		cur, err := database.Collection(name).Find(ctx, bson.D{}, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var tasks []*Task
+		err = cur.All(ctx, &tasks)
+		if err != nil {
+			return nil, err
+		}
+
+		days = append(days, &journalDay{Date: day.Format("2006-01-02"), Tasks: tasks})
+	}
+
+	return days, nil
+}
+
+func printJournal(days []*journalDay) {
+	if jsonOutput {
+		if days == nil {
+			days = []*journalDay{}
+		}
+
+		printJSON(days)
+		return
+	}
+
+	if len(days) == 0 {
+		fmt.Println("No journal entries for these days")
+		return
+	}
+
+	for i, d := range days {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Println(d.Date)
+		printTasks(d.Tasks)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// logFormats are the accepted values of --log-format
+var logFormats = []string{"text", "json"}
+
+// logJSON is set when operational logs are written to stderr as JSON lines
+// instead of the human readable text of the log package
+var logJSON bool
+
+// verbose enables debug logs, such as a trace of every database command
+var verbose bool
+
+func logDebug(msg string, fields ...interface{}) {
+	if verbose {
+		logEntry("debug", msg, fields)
+	}
+}
+
+func logWarn(msg string, fields ...interface{}) {
+	logEntry("warning", msg, fields)
+}
+
+func logError(msg string, fields ...interface{}) {
+	logEntry("error", msg, fields)
+}
+
+// logFatal reports err and exits with a non-zero status. With --json the
+// error is written to stderr as a JSON object, e.g. {"error": "...",
+// "code": 1}, so that scripts can handle failures like any other output.
+func logFatal(err error) {
+	code := 1
+	if ec, ok := err.(cli.ExitCoder); ok && ec.ExitCode() != 0 {
+		code = ec.ExitCode()
+	}
+
+	if jsonOutput {
+		b, _ := json.Marshal(map[string]interface{}{"error": err.Error(), "code": code})
+		fmt.Fprintln(os.Stderr, string(b))
+	} else {
+		logError(err.Error())
+	}
+
+	exit(code)
+}
+
+// logEntry writes a log line. fields are alternating keys and values that
+// are added to the JSON object, or appended as key=value in text mode.
+func logEntry(level, msg string, fields []interface{}) {
+	if logJSON {
+		entry := map[string]interface{}{
+			"time":  time.Now().Format(time.RFC3339),
+			"level": level,
+			"msg":   msg,
+		}
+
+		for i := 0; i+1 < len(fields); i += 2 {
+			entry[fmt.Sprint(fields[i])] = fields[i+1]
+		}
+
+		b, err := json.Marshal(entry)
+		if err != nil {
+			b, _ = json.Marshal(map[string]string{"level": level, "msg": msg})
+		}
+
+		fmt.Fprintln(os.Stderr, string(b))
+		return
+	}
+
+	if level != "error" {
+		msg = level + ": " + msg
+	}
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		msg += fmt.Sprintf(" %v=%v", fields[i], fields[i+1])
+	}
+
+	log.Println(msg)
+}
+
+// commandMonitor traces the commands sent to the database at debug level
+func commandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			logDebug("command started", "command", e.CommandName, "database", e.DatabaseName, "request_id", e.RequestID)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			logDebug("command succeeded", "command", e.CommandName, "request_id", e.RequestID, "duration", time.Duration(e.DurationNanos).String())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			logDebug("command failed", "command", e.CommandName, "request_id", e.RequestID, "error", e.Failure)
+		},
+	}
+}
@@ -1,56 +1,644 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/urfave/cli/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"gopkg.in/gookit/color.v1"
 )
 
+var client *mongo.Client
+var database *mongo.Database
 var collection *mongo.Collection
 var ctx = context.TODO()
 
-func init() {
-	clientOptions := options.Client().ApplyURI("mongodb://localhost:27017/")
-	client, err := mongo.Connect(ctx, clientOptions)
+// maxConflictRetries is the number of times an update is attempted when the
+// task keeps changing underneath it
+const maxConflictRetries = 3
+
+// maxInsertAttempts is the number of times adding a task is attempted when
+// the connection fails, waiting insertRetryDelay longer after each attempt
+const maxInsertAttempts = 3
+
+// insertRetryDelay is the wait after the first failed attempt
+var insertRetryDelay = 200 * time.Millisecond
+
+var errConflict = errors.New("Task was modified by another client, please try again")
+
+var errNotDeleted = errors.New("No tasks were deleted")
+
+var errAlreadyCompleted = errors.New("Task is already completed")
+
+// noMatchError is returned when a task given on the command line doesn't
+// match any task
+type noMatchError struct {
+	msg string
+}
+
+func (e *noMatchError) Error() string {
+	return e.msg
+}
+
+// noConnect stops commands before their first database operation, so that
+// shell completion and scripts can check arguments quickly
+var noConnect bool
+
+// confirmDestructive makes every command that deletes or overwrites tasks
+// ask first, noConfirm makes none of them ask, see mustConfirm
+var confirmDestructive, noConfirm bool
+
+// lenient makes done and rm succeed silently when there is nothing to do:
+// the task doesn't exist, or done is given a task that is already
+// completed. By default those are errors.
+var lenient bool
+
+// local holds the commands that never need the database
+var local = map[string]bool{
+	"profile": true,
+	"whoami":  true,
+	"help":    true,
+	"h":       true,
+}
+
+// pinnedFirst sorts pinned tasks ahead of the others. It leads the sort
+// order of every listing.
+var pinnedFirst = primitive.E{Key: "pinned", Value: -1}
+
+// sortFields are the task fields listings can be sorted by
+var sortFields = []string{"order", "priority", "text", "created_at", "updated_at", "completed_at", "due_date", "completed", "assignee", "project", "seq"}
+
+// listings are the names of the listings whose sort order can be configured
+var listings = []string{"pending", "all", "finished"}
+
+// pendingSort is the order in which pending tasks are listed by default.
+// Task numbers given on the command line refer to this order.
+var pendingSort = bson.D{
+	pinnedFirst,
+	primitive.E{Key: "order", Value: 1},
+	primitive.E{Key: "created_at", Value: 1},
+}
+
+// glyphs maps each --style to the markers printed in front of pending and
+// completed tasks so that status doesn't rely on color alone
+var glyphs = map[string]struct{ pending, doing, high, overdue, completed string }{
+	"none":  {"", "", "", "", ""},
+	"ascii": {"[ ] ", "[~] ", "[^] ", "[!] ", "[x] "},
+	"emoji": {"⬜ ", "🔄 ", "🔥 ", "⏰ ", "✅ "},
+}
+
+var style = "none"
+
+// plain turns off colors, status markers, summaries and the header row at
+// once, see --plain
+var plain bool
+
+// printer is satisfied by the color types we print tasks with
+type printer interface {
+	Printf(format string, a ...interface{})
+}
+
+// colorSchemes maps each --color-scheme to the colors used for pending and
+// completed tasks
+var colorSchemes = map[string]struct{ pending, completed printer }{
+	"default":   {color.Yellow, color.Green},
+	"solarized": {color.C256(136), color.C256(64)},
+	"mono":      {color.Normal, color.Normal},
+}
+
+var scheme = colorSchemes["default"]
+
+// tagColors colors pending tasks after their first tag, so that tasks with
+// the same tag stand out together
+var tagColors bool
+
+// tagPalette holds the colors given to tags. Green and yellow are left out
+// as the default scheme uses them for completed and pending tasks.
+var tagPalette = []printer{
+	color.C256(33),
+	color.C256(135),
+	color.C256(166),
+	color.C256(37),
+	color.C256(168),
+	color.C256(61),
+	color.C256(130),
+	color.C256(98),
+}
+
+// tagColor picks the color of a tag from its name, so that it stays the
+// same from one run to the next
+func tagColor(tag string) printer {
+	h := fnv.New32a()
+	h.Write([]byte(tag))
+	return tagPalette[h.Sum32()%uint32(len(tagPalette))]
+}
+
+// quiet suppresses informational output such as confirmations
+var quiet bool
+
+// outputFormats are the accepted values of --format
+var outputFormats = []string{"text", "json", "ndjson", "tsv", "compact"}
+
+// jsonOutput prints listings as JSON instead of text
+var jsonOutput bool
+
+// ndjsonOutput prints JSON listings one task per line, see printJSON
+var ndjsonOutput bool
+
+// prettyJSON indents JSON output for reading it rather than parsing it
+var prettyJSON bool
+
+// compactOutput prints listings on a single line
+var compactOutput bool
+
+// idLen is the minimum length of the short ids shown in listings, zero hides
+// them. Short ids are the trailing hex digits of a task's ObjectID: the
+// leading digits encode the creation time, so tasks added on the same day
+// share them, while the trailing digits vary from one task to the next.
+var idLen = defaultIDLen
+
+const defaultIDLen = 6
+
+// storeUTC records timestamps in UTC instead of the local time zone. MongoDB
+// stores instants either way, but the zone shows in backups, the offline
+// queue and JSON output of tasks that were just written.
+var storeUTC bool
+
+// now returns the current time in the zone timestamps are stored in
+func now() time.Time {
+	return stored(time.Now())
+}
+
+// stored converts t to the zone timestamps are stored in
+func stored(t time.Time) time.Time {
+	if storeUTC {
+		return t.UTC()
+	}
+
+	return t
+}
+
+// target is where the tasks are stored, as resolved from the flags, the
+// selected profile and the config file
+var target struct {
+	uri        string
+	database   string
+	collection string
+}
+
+// connectTimeout bounds how long we wait for the server before giving up, so
+// that an unreachable database is detected quickly
+const connectTimeout = 5 * time.Second
+
+// clientOptions builds the options used to connect to MongoDB from the
+// command line flags. Settings that aren't given are left to the driver.
+func clientOptions(c *cli.Context) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(target.uri).
+		SetServerSelectionTimeout(connectTimeout)
+
+	// an appName in the connection string wins over the default
+	if opts.AppName == nil || c.IsSet("app-name") {
+		opts.SetAppName(c.String("app-name"))
+	}
+
+	if verbose {
+		opts.SetMonitor(commandMonitor())
+	}
+
+	err := setPassword(opts, c.String("password-file"), c.Args().First() != "prompt" && !noConnect)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Bool("tls") || c.String("tls-ca-file") != "" || c.Bool("tls-insecure") {
+		cfg, err := tlsConfig(c.String("tls-ca-file"), c.Bool("tls-insecure"))
+		if err != nil {
+			return nil, err
+		}
+
+		opts.SetTLSConfig(cfg)
+	}
+
+	if w := c.String("write-concern"); w != "" {
+		if w == "majority" {
+			opts.SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+		} else {
+			n, err := strconv.Atoi(w)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("Invalid write concern %q, expected majority or a number of nodes", w)
+			}
+
+			opts.SetWriteConcern(writeconcern.New(writeconcern.W(n)))
+		}
+	}
+
+	if mode := c.String("read-preference"); mode != "" {
+		m, err := readpref.ModeFromString(mode)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid read preference %q, expected one of primary, primaryPreferred, secondary, secondaryPreferred or nearest", mode)
+		}
+
+		rp, err := readpref.New(m)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.SetReadPreference(rp)
+	}
+
+	return opts, nil
+}
+
+// disconnect closes the connection to the database, if there is one. It is
+// the single teardown path: it runs when tasker returns from main and, via
+// exit, whenever it exits early.
+func disconnect() {
+	if client == nil {
+		return
+	}
+
+	err := client.Disconnect(ctx)
+	if err != nil {
+		logDebug("disconnect failed", "error", err.Error())
+	}
+
+	client = nil
+	logDebug("disconnected")
+}
+
+// exit tears down the connection and exits with the given status. Use it
+// instead of os.Exit, which skips the teardown.
+func exit(code int) {
+	flushPager()
+	disconnect()
+	os.Exit(code)
+}
+
+func connect(clientOptions *options.ClientOptions, databaseName, collectionName string) error {
+	logDebug("connecting", "hosts", strings.Join(clientOptions.Hosts, ","))
+
+	c, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	err = client.Ping(ctx, nil)
+	err = c.Ping(ctx, nil)
 	if err != nil {
-		log.Fatal(err)
+		c.Disconnect(ctx)
+		return err
 	}
 
-	collection = client.Database("tasker").Collection("tasks")
+	client = c
+
+	database = c.Database(databaseName)
+	collection = database.Collection(collectionName)
+	logDebug("connected", "database", database.Name(), "collection", collectionName)
+	return nil
 }
 
 type Task struct {
-	ID        primitive.ObjectID `bson:"_id"`
-	CreatedAt time.Time          `bson:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at"`
-	Text      string             `bson:"text"`
-	Completed bool               `bson:"completed"`
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	Text      string             `bson:"text" json:"text"`
+	Completed bool               `bson:"completed" json:"completed"`
+	Assignee  string             `bson:"assignee,omitempty" json:"assignee,omitempty"`
+	Project   string             `bson:"project,omitempty" json:"project,omitempty"`
+	Tags      []string           `bson:"tags" json:"tags,omitempty"`
+	Pinned    bool               `bson:"pinned,omitempty" json:"pinned,omitempty"`
+	Order     int64              `bson:"order,omitempty" json:"order,omitempty"`
+	Priority  int                `bson:"priority" json:"priority,omitempty"`
+	Link      string             `bson:"link,omitempty" json:"link,omitempty"`
+
+	// Seq is the permanent number of the task within its collection, given
+	// on add and referred to as #N. Unlike the numbers shown by listings it
+	// never changes.
+	Seq int64 `bson:"seq,omitempty" json:"seq,omitempty"`
+
+	// TimeSpent is the time it took to complete the task, if recorded
+	TimeSpent time.Duration `bson:"time_spent,omitempty" json:"time_spent,omitempty"`
+
+	// Notified is set once check-reminders has reported the task as overdue
+	Notified bool `bson:"notified,omitempty" json:"notified,omitempty"`
+
+	CompletedAt *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	DueDate     *time.Time `bson:"due_date,omitempty" json:"due_date,omitempty"`
+
+	// Status refines the state of a pending task, e.g. statusDoing while it
+	// is being worked on. It is cleared when the task is completed.
+	Status string `bson:"status,omitempty" json:"status,omitempty"`
+}
+
+const statusDoing = "doing"
+
+// Priorities range from minPriority, the default, to maxPriority. Tasks at
+// highPriority or above are marked in listings.
+const (
+	minPriority  = 0
+	maxPriority  = 5
+	highPriority = 4
+)
+
+// maxDueYears is how far in the future a due date can be before it is
+// flagged as a likely typo
+const maxDueYears = 5
+
+func (t *Task) isOverdue() bool {
+	return t.overdueAt(time.Now())
+}
+
+// overdueAt reports whether t was overdue at the given time. A task due
+// exactly then isn't overdue yet.
+func (t *Task) overdueAt(at time.Time) bool {
+	return !t.Completed && t.DueDate != nil && t.DueDate.Before(at)
+}
+
+// overdueFilter matches the tasks isOverdue holds for: pending tasks due
+// strictly before now, so a task due this very moment isn't overdue yet.
+// $lt never matches a missing due date, so tasks without one are left out.
+func overdueFilter() bson.D {
+	return bson.D{
+		primitive.E{Key: "completed", Value: false},
+		primitive.E{Key: "due_date", Value: bson.D{primitive.E{Key: "$lt", Value: time.Now()}}},
+	}
+}
+
+// overdueExpr is overdueFilter as an aggregation expression, for counting
+// overdue tasks along with others in a single query
+func overdueExpr() bson.D {
+	return bson.D{primitive.E{Key: "$and", Value: bson.A{
+		bson.D{primitive.E{Key: "$eq", Value: bson.A{"$completed", false}}},
+		// unlike in a query, a missing due date sorts before now here
+		bson.D{primitive.E{Key: "$ifNull", Value: bson.A{"$due_date", false}}},
+		bson.D{primitive.E{Key: "$lt", Value: bson.A{"$due_date", time.Now()}}},
+	}}}
+}
+
+// overdueCount returns the number of overdue tasks
+func overdueCount() (int64, error) {
+	return collection.CountDocuments(ctx, overdueFilter())
 }
 
 func main() {
+	cli.VersionPrinter = printVersion
+
 	app := &cli.App{
-		Name:  "tasker",
-		Usage: "A simple CLI program to manage your tasks",
+		Name:    "tasker",
+		Usage:   "A simple CLI program to manage your tasks",
+		Version: buildVersion,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:    "style",
+				Value:   style,
+				Usage:   "status marker style: none, ascii or emoji",
+				EnvVars: []string{"TASKER_STYLE"},
+			},
+			&cli.IntFlag{
+				Name:    "id-len",
+				Value:   idLen,
+				Usage:   "minimum length of the short ids shown in listings, 0 to hide them (config: id_len)",
+				EnvVars: []string{"TASKER_ID_LEN"},
+			},
+			&cli.BoolFlag{
+				Name:    "emoji",
+				Usage:   "mark task status with emoji, same as --style emoji (needs a terminal font with emoji support)",
+				EnvVars: []string{"TASKER_EMOJI"},
+			},
+			&cli.StringFlag{
+				Name:    "color-scheme",
+				Value:   "default",
+				Usage:   "colors for task status: default, solarized or mono (config: color_scheme)",
+				EnvVars: []string{"TASKER_COLOR_SCHEME"},
+			},
+			&cli.BoolFlag{
+				Name:    "tag-colors",
+				Usage:   "color pending tasks by their first tag (config: tag_colors)",
+				EnvVars: []string{"TASKER_TAG_COLORS"},
+			},
+			&cli.BoolFlag{
+				Name:    "quiet",
+				Aliases: []string{"q"},
+				Usage:   "only print requested data, no confirmations",
+			},
+			&cli.StringFlag{
+				Name:    "format",
+				Value:   "text",
+				Usage:   "`FORMAT` of listings: text, json, ndjson (a JSON object per line), tsv (tab separated, for spreadsheets) or compact (a single line, for status bars)",
+				EnvVars: []string{"TASKER_FORMAT"},
+			},
+			&cli.BoolFlag{
+				Name:  "plain",
+				Usage: "no colors, status markers, summaries or header row, flags given after it still apply",
+			},
+			&cli.BoolFlag{
+				Name:  "no-header",
+				Usage: "leave out the header row of --format tsv",
+			},
+			&cli.BoolFlag{
+				Name:    "paged",
+				Value:   true,
+				Usage:   "page text listings longer than the terminal through $PAGER, or page by page, --paged=false to print them at once",
+				EnvVars: []string{"TASKER_PAGED"},
+			},
+			&cli.IntFlag{
+				Name:  "page-size",
+				Usage: "`LINES` of a page, the height of the terminal by default",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print listings as JSON, same as --format json",
+			},
+			&cli.BoolFlag{
+				Name:    "json-pretty",
+				Aliases: []string{"pretty"},
+				Usage:   "print listings as indented JSON",
+			},
+			&cli.BoolFlag{
+				Name:    "expand-env",
+				Usage:   "show ${VAR} in task texts as the value of the environment variable VAR, the stored text is unchanged",
+				EnvVars: []string{"TASKER_EXPAND_ENV"},
+			},
+			&cli.BoolFlag{
+				Name:  "confirm-destructive",
+				Usage: "ask before every command that deletes or overwrites tasks, unless it is given --force (config: confirm_destructive)",
+			},
+			&cli.BoolFlag{
+				Name:  "no-confirm",
+				Usage: "never ask for confirmation, as if every command was given --force",
+			},
+			&cli.BoolFlag{
+				Name:  "lenient",
+				Usage: "let done and rm succeed when the task doesn't exist or is already completed (config: lenient)",
+			},
+			&cli.BoolFlag{
+				Name:  "strict",
+				Usage: "make done and rm fail when the task doesn't exist or is already completed, the default",
+			},
+			&cli.BoolFlag{
+				Name:  "no-connect",
+				Usage: "check the flags and arguments of a command without connecting to the database, exiting with 0 if they are valid",
+			},
+			&cli.BoolFlag{
+				Name:  "no-db",
+				Usage: "queue add, done and rm locally instead of using the database, replay them with sync",
+			},
+			&cli.BoolFlag{
+				Name:    "store-utc",
+				Usage:   "store timestamps in UTC rather than the local time zone (config: store_utc)",
+				EnvVars: []string{"TASKER_STORE_UTC"},
+			},
+			&cli.StringFlag{
+				Name:    "time-zone",
+				Usage:   "IANA `ZONE` to show and enter times in, e.g. Europe/Berlin (config: time_zone)",
+				EnvVars: []string{"TASKER_TIME_ZONE"},
+			},
+			&cli.StringFlag{
+				Name:    "log-format",
+				Value:   "text",
+				Usage:   "format of the logs written to stderr: text or json",
+				EnvVars: []string{"TASKER_LOG_FORMAT"},
+			},
+			&cli.BoolFlag{
+				Name:    "verbose",
+				Usage:   "log connection events and every database command",
+				EnvVars: []string{"TASKER_VERBOSE"},
+			},
+			&cli.StringFlag{
+				Name:  "env-file",
+				Usage: "load TASKER_* variables from a dotenv `FILE`, variables already set in the environment take precedence",
+			},
+			&cli.StringFlag{
+				Name:    "profile",
+				Usage:   "use the settings of the `NAME`d profile in the config file",
+				EnvVars: []string{"TASKER_PROFILE"},
+			},
+			&cli.StringFlag{
+				Name:    "uri",
+				Value:   "mongodb://localhost:27017/",
+				Usage:   "MongoDB connection string",
+				EnvVars: []string{"TASKER_URI"},
+			},
+			&cli.StringFlag{
+				Name:    "password-file",
+				Usage:   "read the database password from `FILE` rather than the connection string, which is visible in process listings",
+				EnvVars: []string{"TASKER_MONGO_PASSWORD_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "database",
+				Value:   "tasker",
+				Usage:   "`NAME` of the database holding the tasks",
+				EnvVars: []string{"TASKER_DATABASE"},
+			},
+			&cli.StringFlag{
+				Name:    "collection",
+				Value:   "tasks",
+				Usage:   "`NAME` of the collection holding the task list",
+				EnvVars: []string{"TASKER_COLLECTION"},
+			},
+			&cli.BoolFlag{
+				Name:    "collection-per-day",
+				Usage:   "journaling mode, keep each day's tasks in a collection of its own, e.g. tasks_2024_05_01 (config: collection_per_day)",
+				EnvVars: []string{"TASKER_COLLECTION_PER_DAY"},
+			},
+			&cli.StringFlag{
+				Name:    "app-name",
+				Aliases: []string{"mongo-app-name"},
+				Value:   "tasker",
+				Usage:   "`NAME` tasker's connections are shown with in the server's logs and currentOp",
+				EnvVars: []string{"TASKER_APP_NAME"},
+			},
+			&cli.BoolFlag{
+				Name:    "tls",
+				Usage:   "connect using TLS, implied by --tls-ca-file and --tls-insecure",
+				EnvVars: []string{"TASKER_TLS"},
+			},
+			&cli.StringFlag{
+				Name:    "tls-ca-file",
+				Usage:   "also trust the certificate authorities in the PEM `FILE`, e.g. of a managed cluster",
+				EnvVars: []string{"TASKER_TLS_CA_FILE"},
+			},
+			&cli.BoolFlag{
+				Name:    "tls-insecure",
+				Usage:   "don't verify the server's certificate, for testing only",
+				EnvVars: []string{"TASKER_TLS_INSECURE"},
+			},
+			&cli.StringFlag{
+				Name:    "write-concern",
+				Usage:   "acknowledgement required for writes: majority or a number of nodes",
+				EnvVars: []string{"TASKER_WRITE_CONCERN"},
+			},
+			&cli.StringFlag{
+				Name:    "read-preference",
+				Usage:   "replica set members to read from: primary, primaryPreferred, secondary, secondaryPreferred or nearest",
+				EnvVars: []string{"TASKER_READ_PREFERENCE"},
+			},
+		}, listingFlags()...),
+		Before: func(c *cli.Context) error {
+			// nothing to set up for printing the version
+			if c.Args().First() == "version" {
+				return nil
+			}
+
+			err := setup(c)
+			if err != nil && jsonOutput {
+				// without the usage text that would otherwise follow it
+				logFatal(err)
+			}
+
+			return err
+		},
 		Action: func(c *cli.Context) error {
-			tasks, err := getPending()
+			filter, err := listingFilter(c)
+			if err != nil {
+				return err
+			}
+
+			err = listingOutput(c)
+			if err != nil {
+				return err
+			}
+
+			sort, err := listingSort(c, "pending")
+			if err != nil {
+				return err
+			}
+
+			if c.Bool("count") {
+				return printCount(allOf(bson.D{primitive.E{Key: "completed", Value: false}}, filter))
+			}
+
+			tasks, err := getPending(filter, sort)
 			if err != nil {
 				if err == mongo.ErrNoDocuments {
-					fmt.Print("Nothing to see here.\nRun `add 'task'` to add a task")
+					// celebrate only when nothing is filtered out
+					if len(filter) == 0 {
+						return printCaughtUp("Run `add 'task'` to add a task")
+					}
+
+					printNoTasks("Run `add 'task'` to add a task")
 					return nil
 				}
 
@@ -65,60 +653,208 @@ func main() {
 				Name:    "add",
 				Aliases: []string{"a"},
 				Usage:   "add a task to the list",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:    "wip",
+						Usage:   "refuse to add tasks once `N` tasks are pending, 0 for no limit (config: wip_limit)",
+						EnvVars: []string{"TASKER_WIP_LIMIT"},
+					},
+					&cli.BoolFlag{
+						Name:  "at-top",
+						Usage: "put the task at the top of the list instead of the bottom",
+					},
+					&cli.StringFlag{
+						Name:  "due",
+						Usage: "`DATE` the task is due (YYYY-MM-DD [HH:MM], today or tomorrow)",
+					},
+					&cli.StringFlag{
+						Name:  "project",
+						Usage: "`NAME` of the project the task belongs to",
+					},
+					&cli.StringFlag{
+						Name:    "priority",
+						Aliases: []string{"pri"},
+						Usage:   "`PRIORITY` of the task, a number from 0 to 5 or low, medium, high or urgent",
+					},
+					&cli.StringFlag{
+						Name:  "assignee",
+						Usage: "assign the task to `NAME`",
+					},
+					&cli.StringSliceFlag{
+						Name:  "tag",
+						Usage: "`TAG` the task, can be given more than once",
+					},
+					&cli.StringFlag{
+						Name:  "link",
+						Usage: "`URL` of a related page, such as a pull request or ticket, see the open command",
+					},
+					&cli.BoolFlag{
+						Name:  "done",
+						Usage: "log a task that is already completed",
+					},
+					&cli.StringFlag{
+						Name:  "at",
+						Usage: "with --done, record the task as completed at `DATE` instead of now",
+					},
+				},
 				Action: func(c *cli.Context) error {
 					str := c.Args().First()
 					if str == "" {
 						return errors.New("Cannot add an empty task")
 					}
 
-					task := &Task{
-						ID:        primitive.NewObjectID(),
-						CreatedAt: time.Now(),
-						UpdatedAt: time.Now(),
-						Text:      str,
-						Completed: false,
+					// every option is checked before the task is added, in
+					// a single insert with all of them applied
+					task := newTask(str)
+					task.Project = c.String("project")
+					task.Assignee = c.String("assignee")
+					if c.IsSet("priority") {
+						p, err := parsePriority(c.String("priority"))
+						if err != nil {
+							return err
+						}
+
+						task.Priority = p
 					}
 
-					return createTask(task)
-				},
-			},
-			{
-				Name:    "all",
-				Aliases: []string{"l"},
-				Usage:   "list all tasks",
-				Action: func(c *cli.Context) error {
-					tasks, err := getAll()
-					if err != nil {
-						if err == mongo.ErrNoDocuments {
-							fmt.Print("Nothing to see here.\nRun `add 'task'` to add a task")
-							return nil
+					if tags := c.StringSlice("tag"); len(tags) > 0 {
+						task.Tags = tags
+					}
+
+					if c.IsSet("link") {
+						link, err := parseLink(c.String("link"))
+						if err != nil {
+							return err
+						}
+
+						task.Link = link
+					}
+
+					if c.IsSet("due") {
+						due, err := parseDue(c.String("due"))
+						if err != nil {
+							return err
+						}
+
+						task.DueDate = &due
+					}
+
+					if c.Bool("done") {
+						at := now()
+						if c.IsSet("at") {
+							var err error
+							at, err = parseCompletedAt(c.String("at"))
+							if err != nil {
+								return err
+							}
+						}
+
+						task.Completed = true
+						task.CompletedAt = &at
+					} else if c.IsSet("at") {
+						return errors.New("--at can only be used together with --done")
+					}
+
+					if offline {
+						if c.Bool("at-top") {
+							return errors.New("Cannot use --at-top while offline")
+						}
+
+						return queueOp(&queuedOp{Op: "add", Task: task})
+					}
+
+					var err error
+					if !task.Completed {
+						limit := config.WIPLimit
+						if c.IsSet("wip") {
+							limit = c.Int("wip")
+						}
+
+						err = checkWIPLimit(limit)
+						if err != nil {
+							return err
+						}
+					}
+
+					if c.Bool("at-top") {
+						task.Order, err = topOrder()
+						if err != nil {
+							return err
 						}
+					}
 
+					err = createTask(task)
+					if err != nil {
 						return err
 					}
 
-					printTasks(tasks)
+					printAdded(task)
 					return nil
 				},
 			},
 			{
-				Name:    "done",
-				Aliases: []string{"d"},
-				Usage:   "complete a task on the list",
+				Name:  "serve",
+				Usage: "serve the task list over a JSON REST API",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "addr",
+						Value: "localhost:8080",
+						Usage: "address for the HTTP server to listen on",
+					},
+					&cli.BoolFlag{
+						Name:  "ui",
+						Usage: "also serve a web page for managing the tasks at /",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					addr := c.String("addr")
+					fmt.Printf("Serving tasks on http://%s\n", addr)
+					return http.ListenAndServe(addr, newServer(c.Bool("ui")))
+				},
+			},
+			{
+				Name:  "sync",
+				Usage: "replay operations queued while the database was unreachable",
 				Action: func(c *cli.Context) error {
-					text := c.Args().First()
-					return completeTask(text)
+					return syncQueue()
 				},
 			},
 			{
-				Name:    "finished",
-				Aliases: []string{"f"},
-				Usage:   "list completed tasks",
+				Name:    "all",
+				Aliases: []string{"l"},
+				Usage:   "list all tasks",
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{
+						Name:    "completed-last",
+						Value:   true,
+						Usage:   "group completed tasks below pending ones",
+						EnvVars: []string{"TASKER_COMPLETED_LAST"},
+					},
+				}, listingFlags()...),
 				Action: func(c *cli.Context) error {
-					tasks, err := getFinished()
+					filter, err := listingFilter(c)
+					if err != nil {
+						return err
+					}
+
+					err = listingOutput(c)
+					if err != nil {
+						return err
+					}
+
+					sort, err := listingSort(c, "all")
+					if err != nil {
+						return err
+					}
+
+					if c.Bool("count") {
+						return printCount(filter)
+					}
+
+					tasks, err := getAll(c.Bool("completed-last"), filter, sort)
 					if err != nil {
 						if err == mongo.ErrNoDocuments {
-							fmt.Print("Nothing to see here.\nRun `done 'task'` to complete a task")
+							printNoTasks("Run `add 'task'` to add a task")
 							return nil
 						}
 
@@ -130,119 +866,3836 @@ func main() {
 				},
 			},
 			{
-				Name:  "rm",
-				Usage: "deletes a task on the list",
+				Name:      "search",
+				Usage:     "list the tasks whose text contains a term, ignoring case",
+				ArgsUsage: "<term>",
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{
+						Name:  "regex",
+						Usage: "treat the term as a regular expression rather than plain text",
+					},
+				}, listingFlags()...),
 				Action: func(c *cli.Context) error {
-					text := c.Args().First()
-					err := deleteTask(text)
+					term := c.Args().First()
+					if term == "" {
+						return errors.New("No search term specified")
+					}
+
+					match, err := textMatch(term, c.Bool("regex"))
 					if err != nil {
 						return err
 					}
 
-					return nil
-				},
-			},
-		},
-	}
+					filter, err := listingFilter(c)
+					if err != nil {
+						return err
+					}
 
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
-	}
+					err = listingOutput(c)
+					if err != nil {
+						return err
+					}
+
+					sort, err := listingSort(c, "all")
+					if err != nil {
+						return err
+					}
+
+					filter = append(filter, primitive.E{Key: "text", Value: match})
+
+					if c.Bool("count") {
+						return printCount(filter)
+					}
+
+					tasks, err := getAll(true, filter, sort)
+					if err != nil {
+						if err == mongo.ErrNoDocuments {
+							printNoTasks(fmt.Sprintf("No tasks match '%s'\n", term))
+							return nil
+						}
+
+						return err
+					}
+
+					printTasks(tasks)
+					return nil
+				},
+			},
+			{
+				Name:      "done",
+				Aliases:   []string{"d"},
+				Usage:     "complete a task on the list, or the tasks numbered in a range such as '#3-#7'",
+				ArgsUsage: "<task|range>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "at",
+						Usage: "record the task as completed at `DATE` (YYYY-MM-DD [HH:MM]) instead of now",
+					},
+					&cli.StringFlag{
+						Name:  "verify",
+						Usage: "only complete the task if its text is still `TEXT`, guards against the list changing since it was shown",
+					},
+					&cli.BoolFlag{
+						Name:  "last",
+						Usage: "complete the most recently added pending task",
+					},
+					&cli.StringFlag{
+						Name:  "time",
+						Usage: "record `DURATION` as the time spent on the task, e.g. 2h or 45m",
+					},
+					&cli.StringFlag{
+						Name:  "from-file",
+						Usage: "complete the tasks whose ids or short ids are listed in `FILE`, one per line",
+					},
+					&cli.BoolFlag{
+						Name:  "silent-if-missing",
+						Usage: "succeed without printing anything when no task matches, e.g. from cron",
+					},
+					&cli.BoolFlag{
+						Name:    "bell",
+						Usage:   "ring the terminal bell, or run complete_sound_command, once the task is completed (config: bell)",
+						EnvVars: []string{"TASKER_BELL"},
+					},
+				},
+				Action: func(c *cli.Context) error {
+					ref := c.Args().First()
+					verify := c.String("verify")
+
+					at := now()
+					if c.IsSet("at") {
+						var err error
+						at, err = parseCompletedAt(c.String("at"))
+						if err != nil {
+							return err
+						}
+					}
+
+					var spent time.Duration
+					if c.IsSet("time") {
+						var err error
+						spent, err = time.ParseDuration(c.String("time"))
+						if err != nil || spent <= 0 {
+							return fmt.Errorf("Invalid time spent %q, expected a duration such as 2h or 45m", c.String("time"))
+						}
+					}
+
+					if c.Bool("last") && ref != "" {
+						return errors.New("Cannot give a task together with --last")
+					}
+
+					if path := c.String("from-file"); path != "" {
+						if ref != "" || c.Bool("last") || verify != "" || spent > 0 {
+							return errors.New("Cannot use --from-file together with a task, --last, --verify or --time")
+						}
+
+						if offline {
+							return errors.New("Cannot use --from-file while offline")
+						}
+
+						return completeFromFile(path, at)
+					}
+
+					start, end, isRange, err := parseSeqRange(ref)
+					if err != nil {
+						return err
+					}
+
+					if isRange {
+						if c.Bool("last") || verify != "" || spent > 0 {
+							return errors.New("Cannot complete a range of tasks together with --last, --verify or --time")
+						}
+
+						if offline {
+							return errors.New("Cannot complete tasks by number while offline, use their text")
+						}
+
+						tasks, missing, err := completeSeqRange(start, end, at)
+						if err != nil {
+							return err
+						}
+
+						if len(tasks) == 0 {
+							if lenient || c.Bool("silent-if-missing") {
+								return nil
+							}
+
+							return fmt.Errorf("There are no pending tasks numbered %d to %d", start, end)
+						}
+
+						if !quiet {
+							for _, t := range tasks {
+								fmt.Printf("Completed #%d '%s'\n", t.Seq, t.Text)
+							}
+
+							if len(missing) > 0 {
+								fmt.Printf("No pending task numbered %s\n", seqList(missing))
+							}
+						}
+
+						printSummary("task", "completed", int64(len(tasks)), int64(len(missing)))
+
+						if config.Bell || c.Bool("bell") {
+							ringBell()
+						}
+
+						return nil
+					}
+
+					if offline {
+						if c.Bool("last") {
+							return errors.New("Cannot use --last while offline")
+						}
+
+						// Numbers refer to the current listing, which can't be
+						// looked up until the database is back
+						text := ref
+						if verify != "" {
+							text = verify
+						} else if _, ok := parseSeq(ref); ok || isIndex(ref) {
+							return errors.New("Cannot complete a task by number while offline, use its text")
+						}
+
+						return queueOp(&queuedOp{Op: "done", Text: text, At: &at, Spent: spent})
+					}
+
+					var t *Task
+					if c.Bool("last") {
+						t, err = lastAdded()
+					} else {
+						t, err = findTask(ref)
+					}
+					if _, ok := err.(*noMatchError); ok && (lenient || c.Bool("silent-if-missing")) {
+						return nil
+					}
+					if err != nil {
+						return err
+					}
+
+					if verify != "" && t.Text != verify {
+						return fmt.Errorf("Task %s is now '%s' rather than '%s', list your tasks again", ref, t.Text, verify)
+					}
+
+					filter := bson.D{primitive.E{Key: "_id", Value: t.ID}}
+					text := t.Text
+					t, err = completeTask(filter, at, spent)
+					if err == errAlreadyCompleted {
+						if lenient {
+							return nil
+						}
+
+						return fmt.Errorf("'%s' is already completed", text)
+					}
+					if err != nil {
+						return err
+					}
+
+					if !quiet {
+						elapsed := at.Sub(t.CreatedAt)
+						if elapsed < 0 {
+							elapsed = 0
+						}
+
+						details := "open " + humanizeDuration(elapsed)
+						if spent > 0 {
+							details += ", spent " + humanizeDuration(spent)
+						}
+
+						fmt.Printf("Completed '%s' (%s)\n", t.Text, details)
+					}
+
+					if config.Bell || c.Bool("bell") {
+						ringBell()
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      "assign",
+				Usage:     "assign a task to someone, defaults to yourself",
+				ArgsUsage: "<task> [name]",
+				Action: func(c *cli.Context) error {
+					name := c.Args().Get(1)
+					if name == "" {
+						var err error
+						name, err = currentUser()
+						if err != nil {
+							return err
+						}
+					}
+
+					t, err := findTask(c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					fields := bson.D{primitive.E{Key: "assignee", Value: name}}
+					_, err = updateTask(t.ID, fields)
+					if err != nil {
+						return err
+					}
+
+					if !quiet {
+						fmt.Printf("Assigned '%s' to %s\n", t.Text, name)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:    "finished",
+				Aliases: []string{"f"},
+				Usage:   "list completed tasks",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "only list tasks completed on or after `DATE`",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "only list tasks completed on or before `DATE`",
+					},
+				}, listingFlags()...),
+				Action: func(c *cli.Context) error {
+					filter, err := listingFilter(c)
+					if err != nil {
+						return err
+					}
+
+					err = listingOutput(c)
+					if err != nil {
+						return err
+					}
+
+					between, err := completedBetween(c.String("from"), c.String("to"))
+					if err != nil {
+						return err
+					}
+
+					filter = allOf(filter, between)
+
+					sort, err := listingSort(c, "finished")
+					if err != nil {
+						return err
+					}
+
+					if c.Bool("count") {
+						return printCount(allOf(bson.D{primitive.E{Key: "completed", Value: true}}, filter))
+					}
+
+					tasks, err := getFinished(filter, sort)
+					if err != nil {
+						if err == mongo.ErrNoDocuments {
+							printNoTasks("Run `done 'task'` to complete a task")
+							return nil
+						}
+
+						return err
+					}
+
+					printTasks(tasks)
+					return nil
+				},
+			},
+			{
+				Name:      "pin",
+				Usage:     "keep a task at the top of every listing",
+				ArgsUsage: "<task>",
+				Action: func(c *cli.Context) error {
+					return pinTask(c.Args().First(), true)
+				},
+			},
+			{
+				Name:      "unpin",
+				Usage:     "stop keeping a task at the top of listings",
+				ArgsUsage: "<task>",
+				Action: func(c *cli.Context) error {
+					return pinTask(c.Args().First(), false)
+				},
+			},
+			{
+				Name:      "bump",
+				Usage:     "raise the priority of a task by one",
+				ArgsUsage: "<task>",
+				Action: func(c *cli.Context) error {
+					return changePriority(c.Args().First(), 1)
+				},
+			},
+			{
+				Name:      "lower",
+				Usage:     "lower the priority of a task by one",
+				ArgsUsage: "<task>",
+				Action: func(c *cli.Context) error {
+					return changePriority(c.Args().First(), -1)
+				},
+			},
+			{
+				Name:      "rename-project",
+				Usage:     "rename a project on all of its tasks, merging it into the new project if that exists",
+				ArgsUsage: "<old> <new>",
+				Action: func(c *cli.Context) error {
+					from, to := c.Args().Get(0), c.Args().Get(1)
+					if from == "" || to == "" {
+						return errors.New("Both the old and the new project name are required")
+					}
+
+					n, err := renameProject(from, to)
+					if err != nil {
+						return err
+					}
+
+					printSummary("task", "moved to "+to, n, 0)
+					return nil
+				},
+			},
+			{
+				Name:      "due",
+				Usage:     "set the date a task is due",
+				ArgsUsage: "<task> <date>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "clear",
+						Usage: "remove the due date instead",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					t, err := findTask(c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					var due *time.Time
+					if !c.Bool("clear") {
+						if c.Args().Get(1) == "" {
+							return errors.New("No due date specified")
+						}
+
+						d, err := parseDue(c.Args().Get(1))
+						if err != nil {
+							return err
+						}
+
+						due = &d
+					}
+
+					_, err = updateTask(t.ID, bson.D{
+						primitive.E{Key: "due_date", Value: due},
+						primitive.E{Key: "notified", Value: false},
+					})
+					if err != nil {
+						return err
+					}
+
+					if !quiet {
+						if due == nil {
+							fmt.Printf("Cleared the due date of '%s'\n", t.Text)
+						} else {
+							fmt.Printf("'%s' is due %s\n", t.Text, due.Format("2006-01-02 15:04"))
+						}
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "profile",
+				Usage: "manage the profiles in the config file",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "list the configured profiles",
+						Action: func(c *cli.Context) error {
+							return listProfiles(c.String("profile"))
+						},
+					},
+				},
+			},
+			{
+				Name:  "template",
+				Usage: "save the pending tasks as a template and add them again later",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "save",
+						Usage:     "save the texts, tags, projects and priorities of the pending tasks as a template",
+						ArgsUsage: "NAME",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "replace an existing template of the same name",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							tmpl, err := saveTemplate(c.Args().First(), c.Bool("force"))
+							if err != nil {
+								return err
+							}
+
+							if !quiet {
+								fmt.Printf("Saved %s as template '%s'\n", plural(int64(len(tmpl.Tasks)), "task"), tmpl.Name)
+							}
+
+							return nil
+						},
+					},
+					{
+						Name:      "apply",
+						Usage:     "add the tasks of a template as new pending tasks",
+						ArgsUsage: "NAME",
+						Action: func(c *cli.Context) error {
+							added, err := applyTemplate(c.Args().First())
+							if err != nil {
+								return err
+							}
+
+							if jsonOutput {
+								printJSON(added)
+							} else if !quiet {
+								fmt.Printf("Added %s from template '%s'\n", plural(int64(len(added)), "task"), c.Args().First())
+							}
+
+							return nil
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "list the saved templates",
+						Action: func(c *cli.Context) error {
+							list, err := listTemplates()
+							if err != nil {
+								return err
+							}
+
+							printTemplates(list)
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "whoami",
+				Usage: "show where tasks are read from and written to, and the settings in effect",
+				Action: func(c *cli.Context) error {
+					printContext(c)
+					return nil
+				},
+			},
+			{
+				Name:  "prompt",
+				Usage: "print the number of pending and overdue tasks for a shell prompt",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Value: defaultPromptFormat,
+						Usage: "Go `TEMPLATE` for the output, with the counts in {{.Pending}} and {{.Overdue}}",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					printPrompt(c.String("format"))
+					return nil
+				},
+			},
+			{
+				Name:  "check-reminders",
+				Usage: "notify about tasks that have become overdue, e.g. from cron (config: notify_command)",
+				Action: func(c *cli.Context) error {
+					return checkReminders()
+				},
+			},
+			{
+				Name:      "reschedule-overdue",
+				Usage:     "move the due date of every overdue task to a new date, or a duration from now",
+				ArgsUsage: "<date|duration>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "reschedule without asking for confirmation",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					arg := c.Args().First()
+					if arg == "" {
+						return errors.New("No new due date specified")
+					}
+
+					due, err := parseReschedule(arg)
+					if err != nil {
+						return err
+					}
+
+					n, err := overdueCount()
+					if err != nil {
+						return err
+					}
+
+					if n == 0 {
+						if !quiet {
+							fmt.Println("No tasks are overdue")
+						}
+
+						return nil
+					}
+
+					if mustConfirm(c, true) {
+						ok, err := confirm(fmt.Sprintf("Move %s to %s?", plural(n, "overdue task"), due.Local().Format("2006-01-02 15:04")))
+						if err != nil {
+							return err
+						}
+
+						if !ok {
+							return errors.New("Reschedule cancelled")
+						}
+					}
+
+					update := bson.D{primitive.E{Key: "$set", Value: bson.D{
+						primitive.E{Key: "due_date", Value: due},
+						primitive.E{Key: "notified", Value: false},
+						primitive.E{Key: "updated_at", Value: now()},
+					}}}
+
+					res, err := collection.UpdateMany(ctx, overdueFilter(), update)
+					if err != nil {
+						return err
+					}
+
+					printSummary("task", "rescheduled", res.ModifiedCount, 0)
+					return nil
+				},
+			},
+			{
+				Name:      "done-tag",
+				Usage:     "complete every pending task tagged with a tag",
+				ArgsUsage: "<tag>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "complete the tasks without asking for confirmation",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					tag := c.Args().First()
+					if tag == "" {
+						return errors.New("No tag specified")
+					}
+
+					filter := bson.D{primitive.E{Key: "tags", Value: tag}}
+					pending := append(bson.D{primitive.E{Key: "completed", Value: false}}, filter...)
+
+					n, err := collection.CountDocuments(ctx, pending)
+					if err != nil {
+						return err
+					}
+
+					if n == 0 {
+						if !quiet {
+							fmt.Printf("No pending tasks are tagged #%s\n", tag)
+						}
+
+						return nil
+					}
+
+					if mustConfirm(c, true) {
+						ok, err := confirm(fmt.Sprintf("Complete %s tagged #%s?", plural(n, "pending task"), tag))
+						if err != nil {
+							return err
+						}
+
+						if !ok {
+							return errors.New("Completion cancelled")
+						}
+					}
+
+					done, err := completeMany(filter, now())
+					if err != nil {
+						return err
+					}
+
+					printSummary("task", "completed", done, 0)
+					return nil
+				},
+			},
+			{
+				Name:      "focus",
+				Usage:     "work on a task for a while, 25 minutes unless a duration such as 50m is given",
+				ArgsUsage: "<task> [duration]",
+				Action: func(c *cli.Context) error {
+					d := defaultFocus
+					if arg := c.Args().Get(1); arg != "" {
+						var err error
+						d, err = time.ParseDuration(arg)
+						if err != nil || d <= 0 {
+							return fmt.Errorf("Invalid duration %q, expected something like 25m or 1h", arg)
+						}
+					}
+
+					t, err := findTask(c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					if t.Completed {
+						return fmt.Errorf("'%s' is already completed", t.Text)
+					}
+
+					return focus(t, d)
+				},
+			},
+			{
+				Name:      "show",
+				Aliases:   []string{"s"},
+				Usage:     "show the details of a task",
+				ArgsUsage: "<task>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the task as a JSON object, and errors as JSON too",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Bool("json") {
+						jsonOutput = true
+					}
+
+					t, err := findTask(c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					if jsonOutput {
+						printJSON(t)
+						return nil
+					}
+
+					return showTask(t)
+				},
+			},
+			{
+				Name:      "open",
+				Usage:     "open the link of a task in the default browser",
+				ArgsUsage: "<task>",
+				Action: func(c *cli.Context) error {
+					t, err := findTask(c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					if t.Link == "" {
+						return fmt.Errorf("'%s' has no link, add one with add --link", t.Text)
+					}
+
+					return openURL(t.Link)
+				},
+			},
+			{
+				Name:      "move-to",
+				Usage:     "move a task to the list in another collection",
+				ArgsUsage: "<collection> <task>",
+				Action: func(c *cli.Context) error {
+					target := c.Args().First()
+					if target == "" {
+						return errors.New("No target collection specified")
+					}
+
+					if target == collection.Name() {
+						return fmt.Errorf("Task is already in %s", target)
+					}
+
+					t, err := findTask(c.Args().Get(1))
+					if err != nil {
+						return err
+					}
+
+					err = moveTask(t, database.Collection(target))
+					if err != nil {
+						return err
+					}
+
+					if !quiet {
+						fmt.Printf("Moved '%s' to %s\n", t.Text, target)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "backup",
+				Usage: "write every task and its metadata to a backup file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "out",
+						Aliases:  []string{"o"},
+						Usage:    "`FILE` to write the backup to",
+						Required: true,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					n, err := writeBackup(c.String("out"))
+					if err != nil {
+						return err
+					}
+
+					printSummary("task", "backed up", int64(n), 0)
+					return nil
+				},
+			},
+			{
+				Name:      "restore",
+				Usage:     "restore the tasks from a backup file",
+				ArgsUsage: "<file>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "overwrite existing tasks without asking",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					path := c.Args().First()
+					if path == "" {
+						return errors.New("No backup file specified")
+					}
+
+					b, err := readBackup(path)
+					if err != nil {
+						return err
+					}
+
+					n, err := existingTasks(b)
+					if err != nil {
+						return err
+					}
+
+					if n > 0 && mustConfirm(c, true) {
+						ok, err := confirm(fmt.Sprintf("%s from the backup already exist and will be overwritten. Continue?", plural(n, "task")))
+						if err != nil {
+							return err
+						}
+
+						if !ok {
+							return errors.New("Restore cancelled")
+						}
+					}
+
+					restored, err := restoreBackup(b)
+					if err != nil {
+						return err
+					}
+
+					printSummary("task", "restored", restored, 0)
+					return nil
+				},
+			},
+			{
+				Name:      "import",
+				Usage:     "add the tasks from a file written by export, as new tasks",
+				ArgsUsage: "<file>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "merge-by-text",
+						Usage: "replace an existing task with the same text instead of adding a duplicate",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					path := c.Args().First()
+					if path == "" {
+						return errors.New("No file to import specified")
+					}
+
+					tasks, err := readImport(path)
+					if err != nil {
+						return err
+					}
+
+					added, replaced, err := importTasks(tasks, c.Bool("merge-by-text"))
+					printSummary("task", "imported", added, 0)
+					if c.Bool("merge-by-text") {
+						printSummary("task", "updated", replaced, 0)
+					}
+
+					return err
+				},
+			},
+			{
+				Name:      "journal",
+				Usage:     "show the tasks of a day kept with --collection-per-day, today by default",
+				ArgsUsage: "[date]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "show every day from `DATE` on",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "show every day up to `DATE`",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					from, to := time.Now(), time.Now()
+					if day := c.Args().First(); day != "" {
+						if c.IsSet("from") || c.IsSet("to") {
+							return errors.New("Give either a date or --from and --to")
+						}
+
+						d, err := parseDate(day)
+						if err != nil {
+							return err
+						}
+
+						from, to = d, d
+					}
+
+					if c.IsSet("from") {
+						d, err := parseDate(c.String("from"))
+						if err != nil {
+							return err
+						}
+
+						from = d
+					}
+
+					if c.IsSet("to") {
+						d, err := parseDate(c.String("to"))
+						if err != nil {
+							return err
+						}
+
+						to = d
+						if !c.IsSet("from") {
+							from = time.Time{}
+						}
+					}
+
+					days, err := journal(setting(c, "collection", profile.Collection), from, to)
+					if err != nil {
+						return err
+					}
+
+					printJournal(days)
+					return nil
+				},
+			},
+			{
+				Name:  "export",
+				Usage: "print every task, as JSON unless --format is given",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "include-hidden",
+						Usage: "also print the deleted tasks recorded by audit_deletes, marked as deleted (json and ndjson only)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if !c.IsSet("format") && !tsvOutput {
+						jsonOutput = true
+					}
+
+					if c.Bool("include-hidden") && !jsonOutput {
+						return errors.New("--include-hidden needs --format json or ndjson")
+					}
+
+					opts := options.Find().SetSort(bson.D{primitive.E{Key: "created_at", Value: 1}})
+					tasks, err := filterTasks(bson.D{}, opts)
+					// an empty collection exports as an empty list
+					if err != nil && err != mongo.ErrNoDocuments {
+						return err
+					}
+
+					if !c.Bool("include-hidden") {
+						if tasks == nil {
+							tasks = []*Task{}
+						}
+
+						printTasks(tasks)
+						return nil
+					}
+
+					hidden, err := listHidden(tasks)
+					if err != nil {
+						return err
+					}
+
+					records := make([]interface{}, 0, len(tasks)+len(hidden))
+					for _, t := range tasks {
+						records = append(records, t)
+					}
+					for _, h := range hidden {
+						records = append(records, h)
+					}
+
+					printJSON(records)
+					return nil
+				},
+			},
+			{
+				Name:  "stats",
+				Usage: "count the tasks created and completed, and those pending and overdue now",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "since",
+						Usage: "only count tasks created or completed in the last `WINDOW`, e.g. 7d, 2w or 12h (pending and overdue tasks are always counted as of now)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					var since *time.Time
+					if c.IsSet("since") {
+						d, err := parseWindow(c.String("since"))
+						if err != nil {
+							return err
+						}
+
+						t := time.Now().Add(-d)
+						since = &t
+					}
+
+					stats, err := getStats(since)
+					if err != nil {
+						return err
+					}
+
+					printStats(stats)
+					return nil
+				},
+			},
+			{
+				Name:  "report",
+				Usage: "chart the tasks completed on each day",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "week",
+						Usage: "report on the last 7 days, the default",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					days, err := completedPerDay(7)
+					if err != nil {
+						return err
+					}
+
+					printReport(days)
+					return nil
+				},
+			},
+			{
+				Name:  "find-duplicates",
+				Usage: "list tasks that share the same text",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "merge",
+						Usage: "keep the oldest task of each group and delete the rest",
+					},
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "with --merge, delete without asking for confirmation",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					dups, err := findDuplicates()
+					if err != nil {
+						return err
+					}
+
+					if len(dups) == 0 {
+						fmt.Println("No duplicate tasks found")
+						return nil
+					}
+
+					for _, d := range dups {
+						ids := make([]string, len(d.IDs))
+						for i, id := range d.IDs {
+							ids[i] = id.Hex()
+						}
+
+						fmt.Printf("'%s' x%d: %s\n", d.Text, len(d.IDs), strings.Join(ids, ", "))
+					}
+
+					if !c.Bool("merge") {
+						return nil
+					}
+
+					if mustConfirm(c, false) {
+						var extra int64
+						for _, d := range dups {
+							extra += int64(len(d.IDs) - 1)
+						}
+
+						ok, err := confirm(fmt.Sprintf("Delete %s, keeping the oldest of each?", plural(extra, "duplicate task")))
+						if err != nil {
+							return err
+						}
+
+						if !ok {
+							return errors.New("Merge cancelled")
+						}
+					}
+
+					n, err := mergeDuplicates(dups)
+					if err != nil {
+						return err
+					}
+
+					printSummary("task", "removed", n, 0)
+					return nil
+				},
+			},
+			{
+				Name:      "merge",
+				Usage:     "combine tasks into one, appending their texts and tags to the first and deleting the others",
+				ArgsUsage: "<task> <task>...",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "merge without asking for confirmation",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 2 {
+						return errors.New("Give the task to keep followed by the tasks to merge into it")
+					}
+
+					keep, err := findTask(c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					var others []*Task
+					for _, ref := range c.Args().Slice()[1:] {
+						t, err := findTask(ref)
+						if err != nil {
+							return err
+						}
+
+						if t.ID == keep.ID {
+							return fmt.Errorf("Cannot merge '%s' into itself", t.Text)
+						}
+
+						others = append(others, t)
+					}
+
+					if mustConfirm(c, false) {
+						ok, err := confirm(fmt.Sprintf("Merge %s into '%s' and delete them?", plural(int64(len(others)), "task"), keep.Text))
+						if err != nil {
+							return err
+						}
+
+						if !ok {
+							return errors.New("Merge cancelled")
+						}
+					}
+
+					t, err := mergeTasks(keep, others)
+					if err != nil {
+						return err
+					}
+
+					if !quiet {
+						fmt.Printf("Merged %s into '%s'\n", plural(int64(len(others)), "task"), t.Text)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "migrate",
+				Usage: "fill in the fields that tasks created by older versions lack",
+				Action: func(c *cli.Context) error {
+					n, err := migrate()
+					if err != nil {
+						return err
+					}
+
+					printSummary("task", "migrated", n, 0)
+					return nil
+				},
+			},
+			{
+				Name:      "edit",
+				Usage:     "replace the text of a task, or add to it with --append or --prepend",
+				ArgsUsage: "<task> [text]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "append",
+						Usage: "add `TEXT` to the end of the task",
+					},
+					&cli.StringFlag{
+						Name:  "prepend",
+						Usage: "add `TEXT` to the start of the task",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					text := c.Args().Get(1)
+					extend := c.IsSet("append") || c.IsSet("prepend")
+					if text != "" && extend {
+						return errors.New("Cannot give new text together with --append or --prepend")
+					}
+
+					if text == "" && !extend {
+						return errors.New("No text specified, give the new text or use --append or --prepend")
+					}
+
+					t, err := findTask(c.Args().First())
+					if err != nil {
+						return err
+					}
+
+					if extend {
+						t, err = extendText(t.ID, c.String("prepend"), c.String("append"))
+					} else {
+						t, err = updateTask(t.ID, bson.D{primitive.E{Key: "text", Value: text}})
+					}
+					if err != nil {
+						return err
+					}
+
+					if !quiet {
+						fmt.Printf("Updated the task to '%s'\n", t.Text)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:      "recover-deleted",
+				Usage:     "list the tasks recorded by audit_deletes, or add one of them back",
+				ArgsUsage: "[task]",
+				Action: func(c *cli.Context) error {
+					ref := c.Args().First()
+					if ref == "" {
+						list, err := listDeleted()
+						if err != nil {
+							return err
+						}
+
+						printDeleted(list)
+						return nil
+					}
+
+					t, err := recoverDeleted(ref)
+					if err != nil {
+						return err
+					}
+
+					if !quiet {
+						fmt.Printf("Recovered '%s'\n", t.Text)
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "edit-all",
+				Usage: "edit the pending tasks in $EDITOR, one per line",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "delete the tasks whose lines were deleted without asking, even with --confirm-destructive",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return editAll(mustConfirm(c, false))
+				},
+			},
+			{
+				Name:  "reset",
+				Usage: "delete every task in the collection",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "delete without asking for confirmation",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if mustConfirm(c, true) {
+						if !isTerminal(os.Stdin) {
+							return errors.New("Refusing to reset without a terminal to confirm, use --force")
+						}
+
+						name := collection.Name()
+						answer, err := prompt(fmt.Sprintf("This deletes every task in %q and cannot be undone. Type the collection name to confirm:", name))
+						if err != nil {
+							return err
+						}
+
+						if answer != name {
+							return errors.New("Reset cancelled")
+						}
+					}
+
+					n, err := resetTasks()
+					if err != nil {
+						return err
+					}
+
+					printSummary("task", "deleted", n, 0)
+					return nil
+				},
+			},
+			{
+				Name:  "rm",
+				Usage: "deletes a task on the list, given its number in the listing or such as #12, its id, short id or text",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "completed",
+						Usage: "delete every completed task",
+					},
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "delete every task, pending or completed",
+					},
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "delete without asking for confirmation",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					text := c.Args().First()
+					if c.Bool("completed") || c.Bool("all") {
+						if c.Bool("completed") && c.Bool("all") {
+							return errors.New("Cannot use --completed together with --all")
+						}
+
+						if text != "" {
+							return errors.New("Cannot give a task together with --completed or --all")
+						}
+
+						if offline {
+							return errors.New("Cannot delete tasks in bulk while offline")
+						}
+
+						filter, noun := bson.D{}, "task"
+						if c.Bool("completed") {
+							filter, noun = bson.D{primitive.E{Key: "completed", Value: true}}, "completed task"
+						}
+
+						n, err := collection.CountDocuments(ctx, filter)
+						if err != nil {
+							return err
+						}
+
+						if n == 0 {
+							if !quiet {
+								fmt.Println("There are no tasks to delete")
+							}
+
+							return nil
+						}
+
+						if mustConfirm(c, true) {
+							ok, err := confirm(fmt.Sprintf("Delete %s? This cannot be undone", plural(n, noun)))
+							if err != nil {
+								return err
+							}
+
+							if !ok {
+								return errors.New("Deletion cancelled")
+							}
+						}
+
+						deleted, err := deleteTasks(filter, true)
+						if err != nil {
+							return err
+						}
+
+						printSummary("task", "deleted", deleted, 0)
+						return nil
+					}
+
+					if offline {
+						// numbers and short ids can't be looked up until the
+						// database is back
+						if _, ok := parseSeq(text); ok || isIndex(text) {
+							return errors.New("Cannot remove a task by number while offline, use its text")
+						}
+
+						if mustConfirm(c, false) {
+							ok, err := confirm(fmt.Sprintf("Delete '%s'?", text))
+							if err != nil {
+								return err
+							}
+
+							if !ok {
+								return errors.New("Deletion cancelled")
+							}
+						}
+
+						return queueOp(&queuedOp{Op: "rm", Text: text})
+					}
+
+					t, err := findTask(text)
+					if err == nil && mustConfirm(c, false) {
+						ok, cerr := confirm(fmt.Sprintf("Delete '%s'?", t.Text))
+						if cerr != nil {
+							return cerr
+						}
+
+						if !ok {
+							return errors.New("Deletion cancelled")
+						}
+					}
+					if err == nil {
+						err = deleteTaskByID(t.ID)
+						if err == mongo.ErrNoDocuments {
+							// deleted by someone else since it was found
+							err = errNotDeleted
+						}
+					}
+					if _, ok := err.(*noMatchError); ok && lenient {
+						return nil
+					}
+					if err == errNotDeleted && lenient {
+						return nil
+					}
+					if err != nil {
+						return err
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "version",
+				Usage: "print the version of tasker, the commit and date it was built from and the Go version",
+				Action: func(c *cli.Context) error {
+					printVersion(c)
+					return nil
+				},
+			},
+		},
+	}
+
+	err := app.Run(os.Args)
+	if noConnect && err == mongo.ErrClientDisconnected {
+		// the command got as far as the database, so its input is valid
+		err = nil
+	}
+
+	flushPager()
+	if err != nil {
+		logFatal(err)
+	}
+
+	disconnect()
+}
+
+// helpRequested reports whether --help or -h is given anywhere on the
+// command line, for the app or a command, which needs no database
+func helpRequested() bool {
+	for _, arg := range os.Args[1:] {
+		if arg == "--" {
+			break
+		}
+
+		if arg == "--help" || arg == "-h" || arg == "-help" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// givenAfter reports whether any of the flags named are given on the command
+// line after the flag first, so that they override it
+func givenAfter(first string, names ...string) bool {
+	position := func(name string) int {
+		at := -1
+		for i, arg := range os.Args[1:] {
+			if arg == "--" {
+				break
+			}
+			if !strings.HasPrefix(arg, "-") {
+				continue
+			}
+
+			arg = strings.TrimLeft(arg, "-")
+			if arg == name || strings.HasPrefix(arg, name+"=") {
+				at = i
+			}
+		}
+
+		return at
+	}
+
+	p := position(first)
+	for _, name := range names {
+		if position(name) > p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setup applies the global flags and the config file, and connects to the
+// database unless the command can do without it
+func setup(c *cli.Context) error {
+	logFormat := c.String("log-format")
+	if !contains(logFormats, logFormat) {
+		return fmt.Errorf("Unknown log format %q, expected text or json", logFormat)
+	}
+
+	logJSON = logFormat == "json"
+	verbose = c.Bool("verbose")
+
+	if path := c.String("env-file"); path != "" {
+		err := loadEnvFile(path)
+		if err != nil {
+			return err
+		}
+
+		err = applyEnv(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if name := c.String("profile"); name != "" {
+		err = useProfile(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	quiet = c.Bool("quiet")
+
+	format := c.String("format")
+	if c.Bool("json") || c.Bool("json-pretty") {
+		format = "json"
+	}
+
+	prettyJSON = c.Bool("json-pretty")
+
+	if !contains(outputFormats, format) {
+		return fmt.Errorf("Unknown format %q, expected one of %s", format, strings.Join(outputFormats, ", "))
+	}
+
+	jsonOutput = format == "json" || format == "ndjson"
+	ndjsonOutput = format == "ndjson"
+	tsvOutput = format == "tsv"
+	noHeader = c.Bool("no-header")
+	paged = c.Bool("paged")
+	pageSize = c.Int("page-size")
+	if pageSize < 0 {
+		return fmt.Errorf("Invalid --page-size %d, expected a number of lines or 0 for the terminal height", pageSize)
+	}
+	expandEnv = c.Bool("expand-env")
+	compactOutput = format == "compact"
+
+	if c.Bool("lenient") && c.Bool("strict") {
+		return errors.New("Cannot use --lenient together with --strict")
+	}
+
+	lenient = (config.Lenient || c.Bool("lenient")) && !c.Bool("strict")
+
+	if c.Bool("confirm-destructive") && c.Bool("no-confirm") {
+		return errors.New("Cannot use --confirm-destructive together with --no-confirm")
+	}
+
+	noConfirm = c.Bool("no-confirm")
+	confirmDestructive = (config.ConfirmDestructive || c.Bool("confirm-destructive")) && !noConfirm
+
+	storeUTC = config.StoreUTC
+	if c.IsSet("store-utc") {
+		storeUTC = c.Bool("store-utc")
+	}
+
+	zone := config.TimeZone
+	if c.IsSet("time-zone") {
+		zone = c.String("time-zone")
+	}
+
+	if zone != "" {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return fmt.Errorf("Unknown time zone %q", zone)
+		}
+
+		// every time is shown and parsed in time.Local
+		time.Local = loc
+	}
+
+	if c.IsSet("id-len") {
+		idLen = c.Int("id-len")
+	} else if config.IDLen != nil {
+		idLen = *config.IDLen
+	}
+
+	if idLen < 0 || idLen > 24 {
+		return fmt.Errorf("Invalid id length %d, expected 0 to 24", idLen)
+	}
+
+	for name, spec := range config.Sort {
+		if !contains(listings, name) {
+			return fmt.Errorf("Unknown listing %q in sort config, expected one of %s", name, strings.Join(listings, ", "))
+		}
+
+		sort, err := sortOrder(spec)
+		if err != nil {
+			return err
+		}
+
+		if name == "pending" {
+			pendingSort = sort
+		}
+	}
+
+	style = c.String("style")
+	if c.Bool("emoji") {
+		style = "emoji"
+	}
+
+	if _, ok := glyphs[style]; !ok {
+		return fmt.Errorf("Unknown style %q", style)
+	}
+
+	tagColors = config.TagColors
+	if c.IsSet("tag-colors") {
+		tagColors = c.Bool("tag-colors")
+	}
+
+	name := setting(c, "color-scheme", config.ColorScheme)
+	if cs, ok := colorSchemes[name]; ok {
+		scheme = cs
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: unknown color scheme %q, using the default\n", name)
+	}
+
+	plain = c.Bool("plain")
+	if plain {
+		if !givenAfter("plain", "style", "emoji") {
+			style = "none"
+		}
+		if !givenAfter("plain", "color-scheme") {
+			scheme = colorSchemes["mono"]
+
+			// mono still writes the codes of the default color, which
+			// pipes and logs would get
+			if !givenAfter("plain", "tag-colors") {
+				color.Enable = false
+			}
+		}
+		if !givenAfter("plain", "tag-colors") {
+			tagColors = false
+		}
+		if !givenAfter("plain", "no-header") {
+			noHeader = true
+		}
+	}
+
+	collectionPerDay = config.CollectionPerDay
+	if c.IsSet("collection-per-day") {
+		collectionPerDay = c.Bool("collection-per-day")
+	}
+
+	target.uri = setting(c, "uri", profile.URI)
+	target.database = setting(c, "database", profile.Database)
+	target.collection = setting(c, "collection", profile.Collection)
+	if collectionPerDay {
+		target.collection = journalCollection(target.collection, time.Now())
+	}
+
+	if local[c.Args().First()] || helpRequested() {
+		return nil
+	}
+
+	noConnect = c.Bool("no-connect")
+
+	opts, err := clientOptions(c)
+	if err != nil {
+		return err
+	}
+
+	if noConnect {
+		// a client that is never connected fails every operation with
+		// mongo.ErrClientDisconnected, without touching the network
+		nc, err := mongo.NewClient(opts)
+		if err != nil {
+			return err
+		}
+
+		database = nc.Database(target.database)
+		collection = database.Collection(target.collection)
+		return nil
+	}
+
+	if c.Args().First() == "prompt" {
+		opts.SetServerSelectionTimeout(promptTimeout)
+	}
+
+	offline = c.Bool("no-db")
+	if offline {
+		if !queueable[c.Args().First()] {
+			return errors.New("This command needs the database and cannot be used with --no-db")
+		}
+
+		return nil
+	}
+
+	err = connect(opts, target.database, target.collection)
+	if err != nil {
+		if c.Args().First() == "prompt" {
+			// stay out of the way of the shell prompt
+			exit(0)
+		}
+
+		if !queueable[c.Args().First()] {
+			logFatal(err)
+		}
+
+		logWarn("cannot reach the database, queueing for the next `tasker sync`", "error", err.Error())
+		offline = true
+		return nil
+	}
+
+	// the shell prompt can't wait for a purge
+	if c.Args().First() != "prompt" {
+		err = purgeExpired()
+		if err != nil {
+			logWarn("cannot purge expired tasks", "error", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// listingFlags returns the flags shared by every command that lists tasks
+func listingFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "filter",
+			Usage: "advanced: only list tasks that also match this MongoDB query given as JSON",
+		},
+		&cli.StringFlag{
+			Name:  "sort",
+			Usage: "sort by comma separated `FIELDS`, each optionally followed by :asc or :desc (config: sort)",
+		},
+		&cli.BoolFlag{
+			Name:  "natural-sort",
+			Usage: "sort by text with numbers in order, e.g. task2 before task10, pinned tasks still first",
+		},
+		&cli.StringFlag{
+			Name:  "project",
+			Usage: "only list tasks in the project `NAME`",
+		},
+		&cli.StringFlag{
+			Name:  "assignee",
+			Usage: "only list tasks assigned to `NAME`",
+		},
+		&cli.StringFlag{
+			Name:  "tag",
+			Usage: "only list tasks tagged `TAG`",
+		},
+		&cli.StringFlag{
+			Name:  "fields",
+			Usage: "only fetch and show the comma separated `FIELDS`, e.g. text,completed,due",
+		},
+		&cli.BoolFlag{
+			Name:  "text-only",
+			Usage: "only print the text of each task, one per line",
+		},
+		&cli.IntFlag{
+			Name:  "limit-text",
+			Usage: "cut the text of each task to `N` characters, 0 for no limit",
+		},
+		&cli.BoolFlag{
+			Name:  "with-ids",
+			Usage: "end each line with the full id of the task",
+		},
+		&cli.BoolFlag{
+			Name:  "count",
+			Usage: "only print the number of tasks the listing would show",
+		},
+		&cli.BoolFlag{
+			Name:  "overdue",
+			Usage: "only list pending tasks whose due date has passed",
+		},
+		&cli.BoolFlag{
+			Name:  "today",
+			Usage: "only list tasks created since midnight",
+		},
+		&cli.BoolFlag{
+			Name:  "mine",
+			Usage: "only list tasks assigned to you ($TASKER_USER or $USER)",
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "only list tasks created in the last `WINDOW`, e.g. 7d, 2w or 12h",
+		},
+		&cli.StringFlag{
+			Name:  "due-within",
+			Usage: "only list pending tasks due in the next `WINDOW`, e.g. 48h or 3d, soonest first",
+		},
+		&cli.BoolFlag{
+			Name:  "or",
+			Usage: "list tasks matching any of --assignee, --mine, --project, --tag, --overdue, --due-within, --since and --today instead of all of them",
+		},
+	}
+}
+
+// listingOutput reads the flags that change how much of each task a listing
+// shows
+func listingOutput(c *cli.Context) error {
+	var err error
+	shownFields, err = parseFields(c.String("fields"))
+	if err != nil {
+		return err
+	}
+
+	naturalSort = c.Bool("natural-sort")
+	if naturalSort && c.IsSet("sort") {
+		return errors.New("Cannot use --natural-sort together with --sort")
+	}
+
+	withIDs = c.Bool("with-ids")
+	limitText = c.Int("limit-text")
+	if limitText < 0 {
+		return fmt.Errorf("Invalid --limit-text %d, expected a number of characters or 0 for no limit", limitText)
+	}
+
+	textOnly = c.Bool("text-only")
+	if textOnly {
+		if jsonOutput || tsvOutput || compactOutput {
+			return errors.New("Cannot use --text-only together with --format or --json")
+		}
+
+		shownFields = []string{"text"}
+	}
+
+	startPager()
+	return nil
+}
+
+// listingSort returns the sort order for the named listing, taken from the
+// --sort flag or else the config file. Tasks coming up with --due-within are
+// sorted by due date unless --sort is given.
+func listingSort(c *cli.Context, listing string) (bson.D, error) {
+	spec := config.Sort[listing]
+	if c.IsSet("due-within") {
+		spec = "due_date"
+	}
+
+	if c.IsSet("sort") {
+		spec = c.String("sort")
+	}
+
+	return sortOrder(spec)
+}
+
+// sortOrder turns a sort spec such as "completed_at:desc,text" into the sort
+// used by a listing. Pinned tasks always come first and the creation time
+// breaks any remaining ties. An empty spec sorts by manual order.
+func sortOrder(spec string) (bson.D, error) {
+	if spec == "" {
+		spec = "order"
+	}
+
+	sort := bson.D{pinnedFirst}
+	for _, key := range strings.Split(spec, ",") {
+		key = strings.TrimSpace(key)
+
+		dir := 1
+		if i := strings.Index(key, ":"); i >= 0 {
+			switch key[i+1:] {
+			case "asc":
+			case "desc":
+				dir = -1
+			default:
+				return nil, fmt.Errorf("Invalid sort direction in %q, expected asc or desc", key)
+			}
+
+			key = key[:i]
+		}
+
+		if !contains(sortFields, key) {
+			return nil, fmt.Errorf("Cannot sort by %q, expected one of %s", key, strings.Join(sortFields, ", "))
+		}
+
+		sort = withSortKey(sort, key, dir)
+	}
+
+	return withSortKey(sort, "created_at", 1), nil
+}
+
+// withSortKey adds a key to sort unless it already sorts by that key
+func withSortKey(sort bson.D, key string, dir interface{}) bson.D {
+	for _, k := range sort {
+		if k.Key == key {
+			return sort
+		}
+	}
+
+	return append(sort, primitive.E{Key: key, Value: dir})
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listingFilter builds the extra conditions requested through the listing
+// flags. They are added to the conditions of the listing itself, so a task
+// must satisfy both to be shown.
+func listingFilter(c *cli.Context) (bson.D, error) {
+	// each flag adds one condition, which may test more than one field
+	var conds []bson.D
+
+	assignee := c.String("assignee")
+	if c.Bool("mine") {
+		if assignee != "" {
+			return nil, errors.New("Cannot use --mine together with --assignee")
+		}
+
+		var err error
+		assignee, err = currentUser()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if assignee != "" {
+		conds = append(conds, bson.D{primitive.E{Key: "assignee", Value: assignee}})
+	}
+
+	if project := c.String("project"); project != "" {
+		conds = append(conds, bson.D{primitive.E{Key: "project", Value: project}})
+	}
+
+	if tag := c.String("tag"); tag != "" {
+		conds = append(conds, bson.D{primitive.E{Key: "tags", Value: tag}})
+	}
+
+	if c.Bool("overdue") {
+		conds = append(conds, overdueFilter())
+	}
+
+	if c.IsSet("due-within") {
+		d, err := parseWindow(c.String("due-within"))
+		if err != nil {
+			return nil, err
+		}
+
+		// a missing due date matches neither bound
+		now := time.Now()
+		conds = append(conds, bson.D{
+			primitive.E{Key: "completed", Value: false},
+			primitive.E{Key: "due_date", Value: bson.D{
+				primitive.E{Key: "$gte", Value: now},
+				primitive.E{Key: "$lte", Value: now.Add(d)},
+			}},
+		})
+	}
+
+	if c.IsSet("since") {
+		d, err := parseWindow(c.String("since"))
+		if err != nil {
+			return nil, err
+		}
+
+		conds = append(conds, bson.D{primitive.E{Key: "created_at", Value: bson.D{
+			primitive.E{Key: "$gte", Value: time.Now().Add(-d)},
+		}}})
+	}
+
+	if c.Bool("today") {
+		// time.Local is the --time-zone, so the day starts at midnight there
+		y, m, d := time.Now().Date()
+		midnight := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+
+		conds = append(conds, bson.D{primitive.E{Key: "created_at", Value: bson.D{
+			primitive.E{Key: "$gte", Value: midnight},
+		}}})
+	}
+
+	if c.Bool("or") && len(conds) > 1 {
+		alts := make(bson.A, len(conds))
+		for i, cond := range conds {
+			alts[i] = cond
+		}
+
+		conds = []bson.D{{primitive.E{Key: "$or", Value: alts}}}
+	}
+
+	if raw := c.String("filter"); raw != "" {
+		var query bson.D
+		err := bson.UnmarshalExtJSON([]byte(raw), false, &query)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --filter JSON: %v", err)
+		}
+
+		// $and keeps the query from overriding the listing's own conditions
+		// when both use the same field. It applies on top of --or.
+		conds = append(conds, bson.D{primitive.E{Key: "$and", Value: bson.A{query}}})
+	}
+
+	return allOf(conds...), nil
+}
+
+// allOf returns a filter matching the tasks that match every one of conds.
+// The conditions are merged into a single document unless two of them use
+// the same key, as a later key would override an earlier one; they are
+// then matched through $and instead.
+func allOf(conds ...bson.D) bson.D {
+	var merged bson.D
+	seen := make(map[string]bool)
+	for _, cond := range conds {
+		for _, e := range cond {
+			if seen[e.Key] {
+				alts := bson.A{}
+				for _, cond := range conds {
+					if len(cond) > 0 {
+						alts = append(alts, cond)
+					}
+				}
+
+				return bson.D{primitive.E{Key: "$and", Value: alts}}
+			}
+
+			seen[e.Key] = true
+			merged = append(merged, e)
+		}
+	}
+
+	return merged
+}
+
+// textMatch returns a case insensitive regex matching the text of tasks that
+// contain term. Unless asRegex is set, the term is taken literally, so that
+// searching for "v1.2" or "a*b" finds exactly that text.
+func textMatch(term string, asRegex bool) (primitive.Regex, error) {
+	pattern := regexp.QuoteMeta(term)
+	if asRegex {
+		// MongoDB uses PCRE rather than the syntax of the regexp package,
+		// but the two agree on enough to catch typos before querying
+		_, err := regexp.Compile(term)
+		if err != nil {
+			return primitive.Regex{}, fmt.Errorf("Invalid regular expression %q: %v", term, err)
+		}
+
+		pattern = term
+	}
+
+	return primitive.Regex{Pattern: pattern, Options: "i"}, nil
+}
+
+func printTasks(tasks []*Task) {
+	if naturalSort {
+		sortNatural(tasks)
+	}
+
+	if jsonOutput && shownFields != nil {
+		list, err := selectFields(tasks)
+		if err != nil {
+			logFatal(err)
+		}
+
+		printJSON(list)
+		return
+	}
+
+	if jsonOutput {
+		printJSON(tasks)
+		return
+	}
+
+	if tsvOutput {
+		printTSV(tasks)
+		return
+	}
+
+	if compactOutput {
+		printCompact(tasks)
+		return
+	}
+
+	if textOnly {
+		for _, t := range tasks {
+			fmt.Println(displayText(t.Text))
+		}
+		return
+	}
+
+	n := shortIDLen(tasks)
+
+	for i, v := range tasks {
+		text := statusGlyph(v) + truncate(displayText(v.Text), limitText)
+		if n > 0 {
+			text = shortID(v.ID, n) + " " + text
+		}
+
+		if v.Pinned {
+			text = "📌 " + text
+		}
+
+		if v.Project != "" {
+			text += " +" + v.Project
+		}
+
+		if v.Assignee != "" {
+			text += " @" + v.Assignee
+		}
+
+		for _, tag := range v.Tags {
+			text += " #" + tag
+		}
+
+		if v.DueDate != nil && !v.Completed {
+			text += " (due " + v.DueDate.Local().Format("2006-01-02") + ")"
+		}
+
+		if withIDs {
+			text += " " + v.ID.Hex()
+		}
+
+		switch {
+		case v.Completed:
+			scheme.completed.Printf("%d: %s\n", i+1, text)
+		case tagColors && len(v.Tags) > 0:
+			tagColor(v.Tags[0]).Printf("%d: %s\n", i+1, text)
+		default:
+			scheme.pending.Printf("%d: %s\n", i+1, text)
+		}
+	}
+}
+
+// printCount prints the number of tasks matching filter, without fetching
+// them
+func printCount(filter bson.D) error {
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	n, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(n)
+	return nil
+}
+
+// printCompact prints tasks on one line with their numbers, leaving out the
+// tasks that don't fit in the terminal
+func printCompact(tasks []*Task) {
+	const sep = " | "
+	width := terminalWidth()
+
+	line := ""
+	for i, t := range tasks {
+		item := fmt.Sprintf("%d:%s", i+1, truncate(displayText(t.Text), limitText))
+		if i > 0 {
+			item = sep + item
+		}
+
+		more := ""
+		if rest := len(tasks) - i - 1; rest > 0 {
+			more = fmt.Sprintf(" (+%d more)", rest)
+		}
+
+		// the first task is always shown, however long it is
+		if i > 0 && utf8.RuneCountInString(line+item+more) > width {
+			line += fmt.Sprintf(" (+%d more)", len(tasks)-i)
+			break
+		}
+
+		line += item
+	}
+
+	fmt.Println(line)
+}
+
+// envReference matches the ${VAR} references expanded by --expand-env
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// displayText returns the text of a task as it is shown to people. With
+// --expand-env, ${VAR} references to variables that are set are replaced
+// by their values; anything else, including $VAR, is shown as stored.
+func displayText(s string) string {
+	if !expandEnv {
+		return s
+	}
+
+	return envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if v, ok := os.LookupEnv(ref[2 : len(ref)-1]); ok {
+			return v
+		}
+
+		return ref
+	})
+}
+
+// truncate cuts s to n characters, the last of them an ellipsis, unless n
+// is zero
+func truncate(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
+
+// printNoTasks is printed in place of an empty listing
+func printNoTasks(hint string) {
+	if jsonOutput {
+		printJSON([]*Task{})
+		return
+	}
+
+	if tsvOutput {
+		printTSV(nil)
+		return
+	}
+
+	if compactOutput {
+		fmt.Println("Nothing to see here.")
+		return
+	}
+
+	if textOnly {
+		return
+	}
+
+	fmt.Print("Nothing to see here.\n" + hint)
+}
+
+// printContext prints the effective settings, to help working out why a
+// listing doesn't show the expected tasks
+func printContext(c *cli.Context) {
+	backend := "mongodb"
+	if c.Bool("no-db") {
+		backend = "offline queue"
+	}
+
+	profileName := c.String("profile")
+	if profileName == "" {
+		profileName = "(none)"
+	}
+
+	scope := "(all projects)"
+	if project := c.String("project"); project != "" {
+		scope = project
+	}
+
+	settings := []struct{ name, value string }{
+		{"Backend", backend},
+		{"URI", maskURI(target.uri)},
+		{"Database", target.database},
+		{"Collection", target.collection},
+		{"Profile", profileName},
+		{"Project", scope},
+	}
+
+	if jsonOutput {
+		m := make(map[string]string, len(settings))
+		for _, s := range settings {
+			m[strings.ToLower(s.name)] = s.value
+		}
+
+		printJSON(m)
+		return
+	}
+
+	for _, s := range settings {
+		fmt.Printf("%-11s %s\n", s.name+":", s.value)
+	}
+}
+
+// maskURI hides the password in a connection string
+func maskURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		// don't risk printing a password we failed to find
+		return "(invalid URI)"
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+
+	return u.String()
+}
+
+// listProfiles prints the configured profiles, marking the current one. The
+// passwords in their URIs are masked.
+func listProfiles(current string) error {
+	names := profileNames()
+
+	if jsonOutput {
+		profiles := make(map[string]*Profile, len(names))
+		for _, name := range names {
+			p := Profile{}
+			if config.Profiles[name] != nil {
+				p = *config.Profiles[name]
+			}
+
+			p.URI = maskURI(p.URI)
+			profiles[name] = &p
+		}
+
+		printJSON(profiles)
+		return nil
+	}
+
+	if len(names) == 0 {
+		fmt.Println("No profiles are configured")
+		return nil
+	}
+
+	for _, name := range names {
+		p := config.Profiles[name]
+		if p == nil {
+			p = &Profile{}
+		}
+
+		marker := "  "
+		if name == current {
+			marker = "* "
+		}
+
+		var details []string
+		for _, v := range []string{maskURI(p.URI), p.Database, p.Collection} {
+			if v != "" {
+				details = append(details, v)
+			}
+		}
+
+		fmt.Printf("%s%s %s\n", marker, name, strings.Join(details, " "))
+	}
+
+	return nil
+}
+
+// printAdded confirms that t was added, giving its short id so that it can
+// be referred to right away
+func printAdded(t *Task) {
+	if jsonOutput {
+		printJSON(t)
+		return
+	}
+
+	if quiet {
+		return
+	}
+
+	// hidden ids are still given here, that's the point of printing it
+	n := idLen
+	if n == 0 {
+		n = defaultIDLen
+	}
+
+	fmt.Printf("Added task %s: %s\n", shortID(t.ID, n), t.Text)
+}
+
+// printCaughtUp is printed in place of the pending listing when there are
+// no pending tasks, along with how many tasks have been completed so far
+func printCaughtUp(hint string) error {
+	if jsonOutput || tsvOutput || compactOutput || textOnly {
+		printNoTasks(hint)
+		return nil
+	}
+
+	if quiet {
+		return nil
+	}
+
+	done, err := collection.CountDocuments(ctx, bson.D{primitive.E{Key: "completed", Value: true}})
+	if err != nil {
+		return err
+	}
+
+	if done == 0 {
+		printNoTasks(hint)
+		return nil
+	}
+
+	prefix := ""
+	if style == "emoji" {
+		prefix = "🎉 "
+	}
+
+	fmt.Printf("%sAll caught up! %s completed so far.\n%s", prefix, plural(done, "task"), hint)
+	return nil
+}
+
+// ringBell rings the terminal bell, or runs complete_sound_command if it is
+// configured. Failing to play a sound doesn't fail the command.
+func ringBell() {
+	// a blank command counts as none
+	args := strings.Fields(config.CompleteSoundCommand)
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, "\a")
+		return
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if err != nil {
+		logWarn("cannot run complete_sound_command", "error", err.Error())
+	}
+}
+
+func printJSON(v interface{}) {
+	if ndjsonOutput && !prettyJSON {
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+			for i := 0; i < rv.Len(); i++ {
+				b, err := json.Marshal(rv.Index(i).Interface())
+				if err != nil {
+					logFatal(err)
+				}
+
+				fmt.Println(string(b))
+			}
+			return
+		}
+	}
+
+	var b []byte
+	var err error
+	if prettyJSON {
+		b, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		b, err = json.Marshal(v)
+	}
+	if err != nil {
+		logFatal(err)
+	}
+
+	fmt.Println(string(b))
+}
+
+func shortID(id primitive.ObjectID, n int) string {
+	h := id.Hex()
+	return h[len(h)-n:]
+}
+
+// shortIDLen returns the length at which the short ids of tasks are all
+// distinct, starting from the configured minimum
+func shortIDLen(tasks []*Task) int {
+	if idLen == 0 {
+		return 0
+	}
+
+	ids := make([]primitive.ObjectID, len(tasks))
+	for i, t := range tasks {
+		ids[i] = t.ID
+	}
+
+	return distinctLen(idLen, ids)
+}
+
+// distinctLen returns the length, from min on, at which the short ids of
+// ids are all distinct
+func distinctLen(min int, ids []primitive.ObjectID) int {
+	for n := min; n < 24; n++ {
+		seen := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			seen[shortID(id, n)] = true
+		}
+
+		if len(seen) == len(ids) {
+			return n
+		}
+	}
+
+	return 24
+}
+
+// statusGlyph returns the marker printed in front of a task for the current
+// --style
+func statusGlyph(t *Task) string {
+	g := glyphs[style]
+	switch {
+	case t.Completed:
+		return g.completed
+	case t.isOverdue():
+		return g.overdue
+	case t.Priority >= highPriority:
+		return g.high
+	case t.Status == statusDoing:
+		return g.doing
+	default:
+		return g.pending
+	}
+}
+
+func newTask(text string) *Task {
+	t := now()
+
+	return &Task{
+		ID:        primitive.NewObjectID(),
+		CreatedAt: t,
+		UpdatedAt: t,
+		Text:      text,
+		Completed: false,
+		Order:     defaultOrder(t),
+		Tags:      []string{},
+	}
+}
+
+// defaultOrder is the manual order given to a task created at t. Deriving it
+// from the creation time puts new tasks at the bottom of the list and keeps
+// the order the same as for tasks added before manual ordering existed.
+func defaultOrder(t time.Time) int64 {
+	return t.UnixNano()
+}
+
+// topOrder returns an order value placing a task above all existing tasks
+func topOrder() (int64, error) {
+	err := backfillOrder()
+	if err != nil {
+		return 0, err
+	}
+
+	opts := options.FindOne().SetSort(bson.D{primitive.E{Key: "order", Value: 1}})
+
+	t := &Task{}
+	err = collection.FindOne(ctx, bson.D{}, opts).Decode(t)
+	if err == mongo.ErrNoDocuments {
+		return defaultOrder(time.Now()), nil
+	}
+
+	return t.Order - 1, err
+}
+
+// backfillOrder gives tasks created before manual ordering existed their
+// default order, so that they can be sorted against explicitly ordered ones
+func backfillOrder() error {
+	filter := bson.D{primitive.E{Key: "order", Value: bson.D{
+		primitive.E{Key: "$exists", Value: false},
+	}}}
+
+	tasks, err := filterTasks(filter)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+
+		return err
+	}
+
+	models := make([]mongo.WriteModel, len(tasks))
+	for i, t := range tasks {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.D{primitive.E{Key: "_id", Value: t.ID}}).
+			SetUpdate(bson.D{primitive.E{Key: "$set", Value: bson.D{
+				primitive.E{Key: "order", Value: defaultOrder(t.CreatedAt)},
+			}}})
+	}
+
+	_, err = collection.BulkWrite(ctx, models)
+	return err
+}
+
+// pinTask pins or unpins the referenced task. Unpinned tasks don't store the
+// field at all so they sort the same way as tasks that were never pinned.
+func pinTask(ref string, pinned bool) error {
+	t, err := findTask(ref)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: t.ID}}
+
+	set := bson.D{primitive.E{Key: "updated_at", Value: now()}}
+	update := bson.D{primitive.E{Key: "$unset", Value: bson.D{
+		primitive.E{Key: "pinned", Value: ""},
+	}}}
+	if pinned {
+		set = append(set, primitive.E{Key: "pinned", Value: true})
+		update = nil
+	}
+	update = append(update, primitive.E{Key: "$set", Value: set})
+
+	_, err = collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if !quiet {
+		if pinned {
+			fmt.Printf("Pinned '%s'\n", t.Text)
+		} else {
+			fmt.Printf("Unpinned '%s'\n", t.Text)
+		}
+	}
+
+	return nil
+}
+
+// changePriority moves the priority of a task up or down by delta, stopping
+// at minPriority and maxPriority
+func changePriority(ref string, delta int) error {
+	t, err := findTask(ref)
+	if err != nil {
+		return err
+	}
+
+	// The bound is checked in the filter so that concurrent bumps cannot
+	// push the priority out of range
+	bound := primitive.E{Key: "$not", Value: bson.D{primitive.E{Key: "$gte", Value: maxPriority}}}
+	if delta < 0 {
+		bound = primitive.E{Key: "$gt", Value: minPriority}
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: t.ID},
+		primitive.E{Key: "priority", Value: bson.D{bound}},
+	}
+
+	update := bson.D{
+		primitive.E{Key: "$inc", Value: bson.D{primitive.E{Key: "priority", Value: delta}}},
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: now()}}},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(t)
+	if err == mongo.ErrNoDocuments {
+		if !quiet {
+			fmt.Printf("'%s' is already at priority %d\n", t.Text, t.Priority)
+		}
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !quiet {
+		fmt.Printf("'%s' is now at priority %d\n", t.Text, t.Priority)
+	}
+
+	return nil
+}
+
+func showTask(t *Task) error {
+	status := "pending"
+	if t.Status == statusDoing {
+		status = "in progress"
+	}
+
+	if t.Completed {
+		status = "completed"
+	} else {
+		rank, total, err := pendingRank(t)
+		if err != nil {
+			return err
+		}
+
+		status += fmt.Sprintf(" (%d of %d pending)", rank, total)
+	}
+
+	if t.Pinned {
+		status += ", pinned"
+	}
+
+	const layout = "2006-01-02 15:04"
+
+	// Wrap long text ourselves so continuation lines get a hanging indent
+	const indent = "    "
+	for i, line := range wordWrap(displayText(t.Text), terminalWidth()-len(indent)) {
+		if i > 0 {
+			line = indent + line
+		}
+
+		fmt.Println(line)
+	}
+	fmt.Printf("  ID:       %s\n", t.ID.Hex())
+	if t.Seq != 0 {
+		fmt.Printf("  Number:   #%d\n", t.Seq)
+	}
+	fmt.Printf("  Status:   %s\n", status)
+	if t.Priority != 0 {
+		fmt.Printf("  Priority: %d\n", t.Priority)
+	}
+	if t.Project != "" {
+		fmt.Printf("  Project:  %s\n", t.Project)
+	}
+	if len(t.Tags) > 0 {
+		fmt.Printf("  Tags:     %s\n", strings.Join(t.Tags, ", "))
+	}
+	if t.Assignee != "" {
+		fmt.Printf("  Assignee: %s\n", t.Assignee)
+	}
+	if t.Link != "" {
+		fmt.Printf("  Link:     %s\n", t.Link)
+	}
+	fmt.Printf("  Created:  %s (%s ago)\n", t.CreatedAt.Local().Format(layout), humanizeDuration(time.Since(t.CreatedAt)))
+	if t.DueDate != nil {
+		due := t.DueDate.Local().Format(layout)
+		if t.isOverdue() {
+			due += " (overdue)"
+		}
+
+		fmt.Printf("  Due:      %s\n", due)
+	}
+	if t.CompletedAt != nil {
+		fmt.Printf("  Done:     %s\n", t.CompletedAt.Local().Format(layout))
+	}
+	if t.TimeSpent > 0 {
+		fmt.Printf("  Spent:    %s\n", humanizeDuration(t.TimeSpent))
+	}
+	fmt.Printf("  Updated:  %s\n", t.UpdatedAt.Local().Format(layout))
+
+	return nil
+}
+
+// parseLink checks that a link given on the command line is an absolute URL
+func parseLink(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" {
+		return "", fmt.Errorf("Invalid link %q, expected a URL such as https://example.com", s)
+	}
+
+	return u.String(), nil
+}
+
+// openURL opens u with the default handler of the operating system
+func openURL(u string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("Cannot open %s: %v", u, err)
+	}
+
+	return nil
+}
+
+// mustConfirm reports whether a destructive command should ask before going
+// ahead, given whether it asks by default. --force on the command and
+// --no-confirm always skip the question, --confirm-destructive asks even
+// where the command wouldn't.
+func mustConfirm(c *cli.Context, asks bool) bool {
+	if noConfirm || c.Bool("force") {
+		return false
+	}
+
+	return asks || confirmDestructive
+}
+
+// confirm asks the user a yes or no question on the terminal, anything but
+// an explicit yes counts as no
+func confirm(question string) (bool, error) {
+	answer, err := prompt(question + " [y/N]")
+	if err != nil {
+		return false, err
+	}
+
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes", nil
+}
+
+// prompt asks question and returns the line typed in response
+func prompt(question string) (string, error) {
+	fmt.Print(question + " ")
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return strings.TrimSpace(answer), nil
+}
+
+// isTerminal reports whether f is connected to a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// parseDate parses a date given on the command line in the local time zone
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", time.RFC3339} {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid date %q, expected YYYY-MM-DD or YYYY-MM-DD HH:MM", s)
+}
+
+// parseCompletedAt parses a completion date given on the command line,
+// which cannot be in the future
+func parseCompletedAt(s string) (time.Time, error) {
+	at, err := parseDate(s)
+	if err != nil {
+		return at, err
+	}
+
+	if at.After(time.Now()) {
+		return at, errors.New("Cannot complete a task in the future")
+	}
+
+	return stored(at), nil
+}
+
+// parseDue parses a due date given on the command line. A date without a
+// time is due by the end of that day. Dates that are likely typos, in the
+// past or far in the future, are accepted with a warning.
+func parseDue(s string) (time.Time, error) {
+	switch strings.ToLower(s) {
+	case "today":
+		s = time.Now().Format("2006-01-02")
+	case "tomorrow":
+		s = time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	}
+
+	due, err := parseDate(s)
+	if err != nil {
+		return due, err
+	}
+
+	if len(s) == len("2006-01-02") {
+		due = due.AddDate(0, 0, 1).Add(-time.Second)
+	}
+
+	now := time.Now()
+	switch {
+	case due.Before(now):
+		fmt.Fprintf(os.Stderr, "Warning: due date %s is in the past\n", s)
+	case due.After(now.AddDate(maxDueYears, 0, 0)):
+		fmt.Fprintf(os.Stderr, "Warning: due date %s is more than %d years away, is the year right?\n", s, maxDueYears)
+	}
+
+	return stored(due), nil
+}
+
+// priorityNames are the names parsePriority accepts besides numbers
+var priorityNames = map[string]int{
+	"low":    minPriority,
+	"medium": 2,
+	"high":   highPriority,
+	"urgent": maxPriority,
+}
+
+// parsePriority parses a priority given as a number or by name
+func parsePriority(s string) (int, error) {
+	if p, ok := priorityNames[strings.ToLower(s)]; ok {
+		return p, nil
+	}
+
+	p, err := strconv.Atoi(s)
+	if err != nil || p < minPriority || p > maxPriority {
+		return 0, fmt.Errorf("Invalid priority %q, expected a number from %d to %d or low, medium, high or urgent", s, minPriority, maxPriority)
+	}
+
+	return p, nil
+}
+
+// parseReschedule parses the new due date of reschedule-overdue, either a
+// date or a duration from now such as 1d or 2w
+func parseReschedule(s string) (time.Time, error) {
+	if d, err := parseWindow(s); err == nil {
+		return stored(time.Now().Add(d)), nil
+	}
+
+	due, err := parseDue(s)
+	if err != nil {
+		return due, fmt.Errorf("Invalid due date %q, expected a date (YYYY-MM-DD [HH:MM]) or a duration such as 1d", s)
+	}
+
+	return due, nil
+}
+
+// terminalWidth returns the width of the terminal according to stty or else
+// $COLUMNS, falling back to 80 columns
+func terminalWidth() int {
+	if _, cols := ttySize(); cols > 0 {
+		return cols
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+
+	return 80
+}
+
+// wordWrap splits s into lines of at most width characters, breaking at
+// whitespace. Words longer than width are split over as many lines as needed.
+func wordWrap(s string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+
+	var lines []string
+	var line []rune
+
+	for _, field := range strings.Fields(s) {
+		word := []rune(field)
+
+		if len(line) > 0 && len(line)+1+len(word) <= width {
+			line = append(append(line, ' '), word...)
+			continue
+		}
+
+		if len(line) > 0 {
+			lines = append(lines, string(line))
+		}
+
+		for len(word) > width {
+			lines = append(lines, string(word[:width]))
+			word = word[width:]
+		}
+
+		line = word
+	}
+
+	if len(line) > 0 || len(lines) == 0 {
+		lines = append(lines, string(line))
+	}
+
+	return lines
+}
+
+// printSummary reports the outcome of a bulk operation in a uniform way,
+// e.g. "✓ 7 tasks completed, 2 skipped"
+func printSummary(noun, action string, done, skipped int64) {
+	if quiet || plain {
+		return
+	}
+
+	msg := fmt.Sprintf("✓ %s %s", plural(done, noun), action)
+	if skipped > 0 {
+		msg += fmt.Sprintf(", %d skipped", skipped)
+	}
+
+	fmt.Println(msg)
+}
+
+func plural(n int64, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// humanizeDuration formats d using its two most significant units,
+// e.g. "2d 3h" or "45m"
+func humanizeDuration(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+// checkWIPLimit returns an error if adding a pending task would exceed the
+// given limit on the number of pending tasks
+func checkWIPLimit(limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	filter := bson.D{primitive.E{Key: "completed", Value: false}}
+	n, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if n >= int64(limit) {
+		return fmt.Errorf("You already have %d pending tasks (WIP limit %d), finish one before adding more", n, limit)
+	}
+
+	return nil
+}
+
+func createTask(task *Task) error {
+	if task.Seq == 0 {
+		n, err := nextSeq()
+		if err != nil {
+			return err
+		}
+
+		task.Seq = n
+	}
+
+	return insertWithRetry(func() error {
+		_, err := collection.InsertOne(ctx, task)
+		return err
+	})
+}
+
+// insertWithRetry runs insert until it succeeds, fails for good or has been
+// attempted maxInsertAttempts times. The document must come with its id
+// rather than have the database generate one, so an attempt that went
+// through even though its reply was lost shows up as a duplicate key on
+// the next one.
+func insertWithRetry(insert func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := insert()
+		if attempt > 1 && isDuplicateKey(err) {
+			return nil
+		}
+
+		if !isTransient(err) || attempt == maxInsertAttempts {
+			return err
+		}
+
+		logDebug("insert failed, retrying", "attempt", attempt, "error", err.Error())
+		time.Sleep(time.Duration(attempt) * insertRetryDelay)
+	}
+}
+
+// isTransient reports whether err is a network error after which the
+// operation may succeed if tried again
+func isTransient(err error) bool {
+	ce, ok := err.(mongo.CommandError)
+	return ok && (ce.HasErrorLabel("NetworkError") || ce.HasErrorLabel("TransientTransactionError"))
+}
+
+func getAll(completedLast bool, extra bson.D, sort bson.D) ([]*Task, error) {
+	// passing an empty bson.D matches all documents in the collection
+	filter := append(bson.D{}, extra...)
+
+	if completedLast {
+		// pinned completed tasks stay with the other completed tasks
+		grouped := bson.D{primitive.E{Key: "completed", Value: 1}}
+		for _, k := range sort {
+			grouped = withSortKey(grouped, k.Key, k.Value)
+		}
+
+		sort = grouped
+	}
+
+	opts := options.Find().SetSort(sort)
+	return filterTasks(filter, opts)
+}
+
+func filterTasks(filter interface{}, opts ...*options.FindOptions) ([]*Task, error) {
+	// A slice of tasks for storing the decoded documents
+	var tasks []*Task
+
+	if projection := fieldProjection(); projection != nil {
+		opts = append(opts, options.Find().SetProjection(projection))
+	}
+
+	cur, err := collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return tasks, err
+	}
+
+	tasks, err = decodeTasks(cur)
+	if err != nil {
+		return tasks, err
+	}
+
+	if len(tasks) == 0 {
+		return tasks, mongo.ErrNoDocuments
+	}
+
+	return tasks, nil
+}
+
+// taskCursor is the part of *mongo.Cursor decodeTasks reads from
+type taskCursor interface {
+	Next(context.Context) bool
+	Decode(interface{}) error
+	Err() error
+	Close(context.Context) error
+}
+
+// decodeTasks decodes the documents of cur into tasks. cur is closed
+// however it ends, including on a decode error.
+func decodeTasks(cur taskCursor) ([]*Task, error) {
+	var tasks []*Task
+	defer cur.Close(ctx)
+
+	// Iterate through the cursor and decode each document one at a time
+	for cur.Next(ctx) {
+		var t Task
+		err := cur.Decode(&t)
+		if err != nil {
+			return tasks, err
+		}
+
+		tasks = append(tasks, &t)
+	}
+
+	if err := cur.Err(); err != nil {
+		return tasks, err
+	}
+
+	return tasks, nil
+}
+
+// completeTask marks the pending task matching filter as completed at the
+// given time, recording the time spent on it unless that is zero, and
+// returns the task as it was before the update. If only completed tasks
+// match, errAlreadyCompleted is returned.
+func completeTask(filter bson.D, at time.Time, spent time.Duration) (*Task, error) {
+	return completeTaskIn(mongoTasks{collection}, filter, at, spent)
+}
+
+// taskStore is the part of a collection completeTaskIn works with
+type taskStore interface {
+	findOne(filter bson.D) (*Task, error)
+	count(filter bson.D) (int64, error)
+	findOneAndUpdate(filter, update bson.D) (*Task, error)
+}
+
+// mongoTasks is the taskStore of a collection
+type mongoTasks struct {
+	coll *mongo.Collection
+}
+
+func (m mongoTasks) findOne(filter bson.D) (*Task, error) {
+	t := &Task{}
+	err := m.coll.FindOne(ctx, filter).Decode(t)
+	return t, err
+}
+
+func (m mongoTasks) count(filter bson.D) (int64, error) {
+	return m.coll.CountDocuments(ctx, filter)
+}
+
+// findOneAndUpdate returns the task as it was before the update
+func (m mongoTasks) findOneAndUpdate(filter, update bson.D) (*Task, error) {
+	t := &Task{}
+	err := m.coll.FindOneAndUpdate(ctx, filter, update).Decode(t)
+	return t, err
+}
+
+// completeTaskIn is completeTask on the tasks of store
+func completeTaskIn(store taskStore, filter bson.D, at time.Time, spent time.Duration) (*Task, error) {
+	pending := append(bson.D{primitive.E{Key: "completed", Value: false}}, filter...)
+
+	for i := 0; i < maxConflictRetries; i++ {
+		t, err := store.findOne(pending)
+		if err == mongo.ErrNoDocuments {
+			n, cerr := store.count(filter)
+			if cerr != nil {
+				return nil, cerr
+			}
+
+			if n > 0 {
+				return nil, errAlreadyCompleted
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Only update the task if it hasn't been modified by someone else
+		// since it was read. A miss here means we lost the race, so read it
+		// again and retry.
+		guard := bson.D{
+			primitive.E{Key: "_id", Value: t.ID},
+			primitive.E{Key: "updated_at", Value: t.UpdatedAt},
+		}
+
+		set := bson.D{
+			primitive.E{Key: "completed", Value: true},
+			primitive.E{Key: "completed_at", Value: at},
+			primitive.E{Key: "updated_at", Value: now()},
+		}
+		if spent > 0 {
+			set = append(set, primitive.E{Key: "time_spent", Value: spent})
+		}
+
+		update := bson.D{
+			primitive.E{Key: "$set", Value: set},
+			primitive.E{Key: "$unset", Value: bson.D{
+				primitive.E{Key: "status", Value: ""},
+			}},
+		}
+
+		t, err = store.findOneAndUpdate(guard, update)
+		if err != mongo.ErrNoDocuments {
+			return t, err
+		}
+	}
+
+	return nil, errConflict
 }
 
-func printTasks(tasks []*Task) {
-	for i, v := range tasks {
-		if v.Completed {
-			color.Green.Printf("%d: %s\n", i+1, v.Text)
-		} else {
-			color.Yellow.Printf("%d: %s\n", i+1, v.Text)
+// completeMany marks every pending task matching filter as completed at t
+// and returns how many were completed
+func completeMany(filter bson.D, t time.Time) (int64, error) {
+	pending := append(bson.D{primitive.E{Key: "completed", Value: false}}, filter...)
+
+	update := bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "completed", Value: true},
+			primitive.E{Key: "completed_at", Value: t},
+			primitive.E{Key: "updated_at", Value: t},
+		}},
+		primitive.E{Key: "$unset", Value: bson.D{
+			primitive.E{Key: "status", Value: ""},
+		}},
+	}
+
+	res, err := collection.UpdateMany(ctx, pending, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.ModifiedCount, nil
+}
+
+func getPending(extra bson.D, sort bson.D) ([]*Task, error) {
+	filter := allOf(bson.D{primitive.E{Key: "completed", Value: false}}, extra)
+
+	opts := options.Find().SetSort(sort)
+	return filterTasks(filter, opts)
+}
+
+// completedBetween returns the conditions matching tasks completed within
+// the given dates, either of which may be empty. A date without a time
+// includes the whole of that day.
+func completedBetween(from, to string) (bson.D, error) {
+	var bounds bson.D
+
+	if from != "" {
+		t, err := parseDate(from)
+		if err != nil {
+			return nil, err
+		}
+
+		bounds = append(bounds, primitive.E{Key: "$gte", Value: t})
+	}
+
+	if to != "" {
+		t, err := parseDate(to)
+		if err != nil {
+			return nil, err
 		}
+
+		if len(to) == len("2006-01-02") {
+			t = t.AddDate(0, 0, 1)
+		}
+
+		bounds = append(bounds, primitive.E{Key: "$lt", Value: t})
+	}
+
+	if bounds == nil {
+		return nil, nil
 	}
+
+	return bson.D{primitive.E{Key: "completed_at", Value: bounds}}, nil
 }
 
-func createTask(task *Task) error {
-	_, err := collection.InsertOne(ctx, task)
-	return err
+// pendingRank returns the position of t among the pending tasks in the
+// order they are listed, along with the total number of pending tasks
+func pendingRank(t *Task) (int64, int64, error) {
+	pending := bson.D{primitive.E{Key: "completed", Value: false}}
+
+	total, err := collection.CountDocuments(ctx, pending)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	before, err := sortsBefore(pendingSort, t)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	filter := append(pending, before...)
+	n, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return n + 1, total, nil
 }
 
-func getAll() ([]*Task, error) {
-	// passing bson.D{{}} matches all documents in the collection
-	filter := bson.D{{}}
-	return filterTasks(filter)
+// sortsBefore returns a filter matching the tasks that come before t when
+// ordered by sort. Ties are broken by _id, which is what MongoDB falls back
+// to for documents inserted in order.
+func sortsBefore(sort bson.D, t *Task) (bson.D, error) {
+	b, err := bson.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.M
+	err = bson.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := append(append(bson.D{}, sort...), primitive.E{Key: "_id", Value: 1})
+
+	// A task sorts before t if it is equal on all the leading keys and
+	// smaller (or larger, for descending keys) on the next one. Missing
+	// fields sort as null, the smallest value, but comparison operators
+	// never match across types so null has to be handled separately.
+	var or bson.A
+	var equal bson.D
+	for _, k := range keys {
+		v := doc[k.Key]
+		desc := k.Value == -1
+
+		var cond bson.D
+		switch {
+		case v == nil && desc:
+			cond = bson.D{primitive.E{Key: "$ne", Value: nil}}
+		case v == nil:
+			// nothing sorts below null
+		case desc:
+			cond = bson.D{primitive.E{Key: "$gt", Value: v}}
+		default:
+			cond = bson.D{primitive.E{Key: "$not", Value: bson.D{
+				primitive.E{Key: "$gte", Value: v},
+			}}}
+		}
+
+		if cond != nil {
+			or = append(or, append(append(bson.D{}, equal...), primitive.E{Key: k.Key, Value: cond}))
+		}
+
+		equal = append(equal, primitive.E{Key: k.Key, Value: v})
+	}
+
+	if len(or) == 0 {
+		// t comes first, match nothing
+		return bson.D{primitive.E{Key: "_id", Value: bson.D{
+			primitive.E{Key: "$exists", Value: false},
+		}}}, nil
+	}
+
+	return bson.D{primitive.E{Key: "$or", Value: or}}, nil
 }
 
-func filterTasks(filter interface{}) ([]*Task, error) {
-	// A slice of tasks for storing the decoded documents
-	var tasks []*Task
+func getFinished(extra bson.D, sort bson.D) ([]*Task, error) {
+	filter := allOf(bson.D{primitive.E{Key: "completed", Value: true}}, extra)
 
-	cur, err := collection.Find(ctx, filter)
+	opts := options.Find().SetSort(sort)
+	return filterTasks(filter, opts)
+}
+
+// renameProject moves every task in project from to project to and returns
+// the number of tasks changed
+func renameProject(from, to string) (int64, error) {
+	filter := bson.D{primitive.E{Key: "project", Value: from}}
+	update := bson.D{primitive.E{Key: "$set", Value: bson.D{
+		primitive.E{Key: "project", Value: to},
+		primitive.E{Key: "updated_at", Value: now()},
+	}}}
+
+	res, err := collection.UpdateMany(ctx, filter, update)
 	if err != nil {
-		return tasks, err
+		return 0, err
 	}
 
-	// Iterate through the cursor and decode each document one at a time
+	return res.ModifiedCount, nil
+}
+
+// moveTask copies t, id included, into the target collection and then deletes
+// it from the current one. The copy comes first so that a failure part way
+// through can leave the task in both collections but never in neither. The
+// copy is numbered anew, as numbers count up separately in each collection.
+func moveTask(t *Task, target *mongo.Collection) error {
+	n, err := nextSeqIn(target)
+	if err != nil {
+		return err
+	}
+
+	moved := *t
+	moved.Seq = n
+
+	_, err = target.InsertOne(ctx, &moved)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return fmt.Errorf("A task with id %s already exists in %s", t.ID.Hex(), target.Name())
+		}
+
+		return err
+	}
+
+	// not a deletion, so it isn't recorded by audit_deletes
+	res, err := collection.DeleteOne(ctx, bson.D{primitive.E{Key: "_id", Value: t.ID}})
+	if err == nil && res.DeletedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		return fmt.Errorf("Task was copied to %s but could not be removed from %s, remove it with `tasker --collection %s rm '%s'`: %v",
+			target.Name(), collection.Name(), collection.Name(), t.Text, err)
+	}
+
+	return nil
+}
+
+// mergeTasks appends the texts and tags of others to keep, which also takes
+// the earliest creation time of them all, and then deletes others. keep is
+// updated first so that a failure part way through leaves tasks twice rather
+// than losing any.
+func mergeTasks(keep *Task, others []*Task) (*Task, error) {
+	text := keep.Text
+	tags := append([]string{}, keep.Tags...)
+	created := keep.CreatedAt
+
+	ids := bson.A{}
+	for _, t := range others {
+		text += "; " + t.Text
+		for _, tag := range t.Tags {
+			if !contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+
+		if t.CreatedAt.Before(created) {
+			created = t.CreatedAt
+		}
+
+		ids = append(ids, t.ID)
+	}
+
+	merged, err := updateTask(keep.ID, bson.D{
+		primitive.E{Key: "text", Value: text},
+		primitive.E{Key: "tags", Value: tags},
+		primitive.E{Key: "created_at", Value: created},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = deleteTasks(bson.D{primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$in", Value: ids}}}}, true)
+	return merged, err
+}
+
+// duplicate is a group of tasks with identical text, oldest first
+type duplicate struct {
+	Text string               `bson:"_id"`
+	IDs  []primitive.ObjectID `bson:"ids"`
+}
+
+func findDuplicates() ([]*duplicate, error) {
+	pipeline := mongo.Pipeline{
+		bson.D{primitive.E{Key: "$sort", Value: bson.D{
+			primitive.E{Key: "created_at", Value: 1},
+		}}},
+		bson.D{primitive.E{Key: "$group", Value: bson.D{
+			primitive.E{Key: "_id", Value: "$text"},
+			primitive.E{Key: "ids", Value: bson.D{primitive.E{Key: "$push", Value: "$_id"}}},
+			primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}},
+		}}},
+		bson.D{primitive.E{Key: "$match", Value: bson.D{
+			primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$gt", Value: 1}}},
+		}}},
+		bson.D{primitive.E{Key: "$sort", Value: bson.D{
+			primitive.E{Key: "_id", Value: 1},
+		}}},
+	}
+
+	var dups []*duplicate
+
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return dups, err
+	}
+
+	err = cur.All(ctx, &dups)
+	return dups, err
+}
+
+// mergeDuplicates deletes all but the oldest task of each group and returns
+// the number of tasks removed
+func mergeDuplicates(dups []*duplicate) (int64, error) {
+	var extra []primitive.ObjectID
+	for _, d := range dups {
+		extra = append(extra, d.IDs[1:]...)
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: bson.D{
+		primitive.E{Key: "$in", Value: extra},
+	}}}
+
+	return deleteTasks(filter, true)
+}
+
+func deleteTask(text string) error {
+	filter := bson.D{primitive.E{Key: "text", Value: text}}
+
+	n, err := deleteTasks(filter, false)
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errNotDeleted
+	}
+
+	return nil
+}
+
+// resetTasks deletes every task in the collection and returns how many
+// were removed
+func resetTasks() (int64, error) {
+	return deleteTasks(bson.D{}, true)
+}
+
+// completeFromFile completes the tasks listed in the file at path. Each line
+// starts with the id or short id of a task, anything after it is ignored
+// so that listings with ids can be fed back. Blank lines and lines starting
+// with # are skipped.
+func completeFromFile(path string, at time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var done int64
+	var unmatched []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ref := strings.Fields(line)[0]
+
+		t, err := findByID(ref)
+		if err != nil {
+			unmatched = append(unmatched, fmt.Sprintf("%s: %v", ref, err))
+			continue
+		}
+
+		filter := bson.D{primitive.E{Key: "_id", Value: t.ID}}
+		_, err = completeTask(filter, at, 0)
+		if err == errAlreadyCompleted {
+			unmatched = append(unmatched, fmt.Sprintf("%s: '%s' is already completed", ref, t.Text))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		done++
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+
+	printSummary("task", "completed", done, int64(len(unmatched)))
+	for _, u := range unmatched {
+		fmt.Println("  unmatched: " + u)
+	}
+
+	return nil
+}
+
+// findByID returns the task with the given id or short id. Unlike findTask
+// it doesn't accept numbers or text, which could match the wrong task.
+func findByID(ref string) (*Task, error) {
+	if id, err := primitive.ObjectIDFromHex(ref); err == nil {
+		t, err := getTask(id)
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("No task has this id")
+		}
+
+		return t, err
+	}
+
+	if isShortID(ref) {
+		t, err := findByShortID(ref)
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("No task has this id")
+		}
+
+		return t, err
+	}
+
+	return nil, errors.New("Not a task id")
+}
+
+// lastAdded returns the pending task that was created most recently
+func lastAdded() (*Task, error) {
+	filter := bson.D{primitive.E{Key: "completed", Value: false}}
+	opts := options.FindOne().SetSort(bson.D{primitive.E{Key: "created_at", Value: -1}})
+
+	t := &Task{}
+	err := collection.FindOne(ctx, filter, opts).Decode(t)
+	if err == mongo.ErrNoDocuments {
+		return nil, &noMatchError{"There are no pending tasks"}
+	}
+
+	return t, err
+}
+
+// currentUser returns the name tasks are assigned to by default
+func currentUser() (string, error) {
+	for _, env := range []string{"TASKER_USER", "USER"} {
+		if name := os.Getenv(env); name != "" {
+			return name, nil
+		}
+	}
+
+	return "", errors.New("Cannot determine the current user, set TASKER_USER")
+}
+
+// findTask looks up the task a user refers to on the command line. A number
+// refers to the task shown with that number by the default listing, other
+// references are tried as an id, a short id and then as the exact text of
+// the task. A number beyond the end of the listing is still tried as a short
+// id, as short ids can be all digits.
+func findTask(ref string) (*Task, error) {
+	if ref == "" {
+		return nil, errors.New("No task specified")
+	}
+
+	if n, ok := parseSeq(ref); ok {
+		return findBySeq(n)
+	}
+
+	var notListed error
+	if isIndex(ref) {
+		n, _ := strconv.Atoi(ref)
+		filter := bson.D{primitive.E{Key: "completed", Value: false}}
+		opts := options.FindOne().SetSort(pendingSort).SetSkip(int64(n - 1))
+
+		t := &Task{}
+		err := collection.FindOne(ctx, filter, opts).Decode(t)
+		if err != mongo.ErrNoDocuments {
+			return t, err
+		}
+
+		notListed = &noMatchError{fmt.Sprintf("There is no pending task number %d", n)}
+		if !isShortID(ref) {
+			return nil, notListed
+		}
+	}
+
+	if id, err := primitive.ObjectIDFromHex(ref); err == nil {
+		t, err := getTask(id)
+		if err != mongo.ErrNoDocuments {
+			return t, err
+		}
+	}
+
+	if isShortID(ref) {
+		t, err := findByShortID(ref)
+		if err != mongo.ErrNoDocuments {
+			return t, err
+		}
+	}
+
+	if notListed != nil {
+		return nil, notListed
+	}
+
+	// a repeated task such as "buy milk" can be both completed and pending,
+	// the pending one is meant unless it is the only one, which done then
+	// reports as already completed
+	filter := bson.D{primitive.E{Key: "text", Value: ref}}
+	opts := options.FindOne().SetSort(bson.D{
+		primitive.E{Key: "completed", Value: 1},
+		primitive.E{Key: "created_at", Value: 1},
+	})
+
+	t := &Task{}
+	err := collection.FindOne(ctx, filter, opts).Decode(t)
+	if err == mongo.ErrNoDocuments {
+		return nil, &noMatchError{fmt.Sprintf("No task matches '%s'", ref)}
+	}
+
+	return t, err
+}
+
+func isShortID(ref string) bool {
+	if len(ref) < 4 || len(ref) >= 24 {
+		return false
+	}
+
+	_, err := hex.DecodeString(strings.Repeat("0", len(ref)%2) + ref)
+	return err == nil
+}
+
+// findByShortID returns the task whose id ends with the given short id
+func findByShortID(short string) (*Task, error) {
+	short = strings.ToLower(short)
+
+	// two matches are enough to tell that the short id is ambiguous
+	matches, err := shortIDCandidates(short, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, mongo.ErrNoDocuments
+	case 1:
+		return getTask(matches[0])
+	default:
+		return nil, ambiguousShortID(short)
+	}
+}
+
+// shortIDCandidates returns the ids of at most limit tasks whose id ends
+// with short, which is lower case
+func shortIDCandidates(short string, limit int64) ([]primitive.ObjectID, error) {
+	cur, err := collection.Aggregate(ctx, shortIDPipeline(short, limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var ids []primitive.ObjectID
 	for cur.Next(ctx) {
 		var t Task
 		err := cur.Decode(&t)
 		if err != nil {
-			return tasks, err
+			return nil, err
 		}
 
-		tasks = append(tasks, &t)
+		ids = append(ids, t.ID)
 	}
 
-	if err := cur.Err(); err != nil {
-		return tasks, err
+	return ids, cur.Err()
+}
+
+// shortIDPipeline matches at most limit tasks whose id ends with short.
+// ObjectIDs can't be queried by suffix, so each id is matched as hex, which
+// needs MongoDB 4.0 or later for $toString.
+func shortIDPipeline(short string, limit int64) mongo.Pipeline {
+	return mongo.Pipeline{
+		bson.D{primitive.E{Key: "$project", Value: bson.D{
+			primitive.E{Key: "hex", Value: bson.D{primitive.E{Key: "$toString", Value: "$_id"}}},
+		}}},
+		bson.D{primitive.E{Key: "$match", Value: bson.D{
+			primitive.E{Key: "hex", Value: primitive.Regex{Pattern: regexp.QuoteMeta(short) + "$"}},
+		}}},
+		bson.D{primitive.E{Key: "$limit", Value: limit}},
 	}
+}
 
-	// once exhausted, close the cursor
-	cur.Close(ctx)
+// maxCandidates is the number of matching tasks an ambiguous short id error
+// lists
+const maxCandidates = 10
 
-	if len(tasks) == 0 {
-		return tasks, mongo.ErrNoDocuments
+// ambiguousShortID returns the error for a short id matching several tasks.
+// It lists the tasks with their ids shortened only as far as keeps them
+// apart, so that any of them can be copied from the error.
+func ambiguousShortID(short string) error {
+	msg := fmt.Sprintf("Short id %s matches several tasks, use more characters", short)
+
+	// one more than is shown tells whether there are others
+	ids, err := shortIDCandidates(short, maxCandidates+1)
+	if err != nil || len(ids) < 2 {
+		// the candidates are only a help, the ambiguity is the error
+		return errors.New(msg)
 	}
 
-	return tasks, nil
+	shown := ids
+	if len(shown) > maxCandidates {
+		shown = shown[:maxCandidates]
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$in", Value: shown}}}}
+	tasks, err := filterTasks(filter, options.Find().SetSort(bson.D{primitive.E{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return errors.New(msg)
+	}
+
+	n := distinctLen(len(short)+1, shown)
+
+	msg += ":"
+	for _, t := range tasks {
+		msg += fmt.Sprintf("\n  %s  %s", shortID(t.ID, n), t.Text)
+	}
+
+	if len(ids) > maxCandidates {
+		msg += "\n  and more"
+	}
+
+	return errors.New(msg)
 }
 
-func completeTask(text string) error {
-	filter := bson.D{primitive.E{Key: "text", Value: text}}
+// isIndex reports whether ref is a position in the default listing
+func isIndex(ref string) bool {
+	n, err := strconv.Atoi(ref)
+	return err == nil && n > 0
+}
 
-	update := bson.D{primitive.E{Key: "$set", Value: bson.D{
-		primitive.E{Key: "completed", Value: true},
-	}}}
+func getTask(id primitive.ObjectID) (*Task, error) {
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
 
 	t := &Task{}
-	return collection.FindOneAndUpdate(ctx, filter, update).Decode(t)
+	err := collection.FindOne(ctx, filter).Decode(t)
+	return t, err
 }
 
-func getPending() ([]*Task, error) {
-	filter := bson.D{
-		primitive.E{Key: "completed", Value: false},
-	}
+// updateTask applies the given fields to the task with the specified id and
+// returns the task as it is after the update
+func updateTask(id primitive.ObjectID, fields bson.D) (*Task, error) {
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+
+	fields = append(fields, primitive.E{Key: "updated_at", Value: now()})
+	update := bson.D{primitive.E{Key: "$set", Value: fields}}
 
-	return filterTasks(filter)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	t := &Task{}
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(t)
+	return t, err
 }
 
-func getFinished() ([]*Task, error) {
-	filter := bson.D{
-		primitive.E{Key: "completed", Value: true},
+// extendText adds prefix and suffix to the text of the task with the given
+// id and returns the task as it is after the update. The text is joined by
+// the database so that edits made at the same time are all kept, which
+// needs MongoDB 4.2 or later for updates with a pipeline.
+func extendText(id primitive.ObjectID, prefix, suffix string) (*Task, error) {
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+
+	update := mongo.Pipeline{
+		bson.D{primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "text", Value: bson.D{primitive.E{Key: "$concat", Value: bson.A{prefix, "$text", suffix}}}},
+			primitive.E{Key: "updated_at", Value: now()},
+		}}},
 	}
 
-	return filterTasks(filter)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	t := &Task{}
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(t)
+	return t, err
 }
 
-func deleteTask(text string) error {
-	filter := bson.D{primitive.E{Key: "text", Value: text}}
+func deleteTaskByID(id primitive.ObjectID) error {
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
 
-	res, err := collection.DeleteOne(ctx, filter)
+	n, err := deleteTasks(filter, false)
 	if err != nil {
 		return err
 	}
 
-	if res.DeletedCount == 0 {
-		return errors.New("No tasks were deleted")
+	if n == 0 {
+		return mongo.ErrNoDocuments
 	}
 
 	return nil
@@ -0,0 +1,655 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func mustID(t *testing.T, hex string) primitive.ObjectID {
+	t.Helper()
+
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func TestShortIDPipeline(t *testing.T) {
+	ids := []primitive.ObjectID{
+		mustID(t, "5f0000000000000000a1b2c3"),
+		mustID(t, "5f0000000000000000f1b2c3"),
+		mustID(t, "5f000000000000000000dead"),
+	}
+
+	tests := []struct {
+		short string
+		want  int
+	}{
+		{"dead", 1},     // unique
+		{"00dead", 1},   // unique, longer than needed
+		{"b2c3", 2},     // ambiguous
+		{"a1b2c3", 1},   // widened until unique
+		{"beef", 0},     // no match
+		{"5f000000", 0}, // a prefix isn't a short id
+	}
+
+	for _, tt := range tests {
+		p := shortIDPipeline(tt.short, 2)
+		if len(p) != 3 || p[0][0].Key != "$project" || p[1][0].Key != "$match" || p[2][0].Key != "$limit" {
+			t.Fatalf("shortIDPipeline(%q) = %v, want $project, $match and $limit", tt.short, p)
+		}
+
+		if limit := p[2][0].Value; limit != int64(2) {
+			t.Errorf("shortIDPipeline(%q) limits to %v, want 2", tt.short, limit)
+		}
+
+		// the database matches the hex of each id against the regex
+		match := p[1][0].Value.(bson.D)
+		re := regexp.MustCompile(match[0].Value.(primitive.Regex).Pattern)
+
+		got := 0
+		for _, id := range ids {
+			if re.MatchString(id.Hex()) {
+				got++
+			}
+		}
+
+		if match[0].Key != "hex" || got != tt.want {
+			t.Errorf("shortIDPipeline(%q) matched %d ids on %s, want %d on hex", tt.short, got, match[0].Key, tt.want)
+		}
+	}
+}
+
+func TestDistinctLen(t *testing.T) {
+	a := mustID(t, "5f0000000000000000a1b2c3")
+	b := mustID(t, "5f0000000000000000f1b2c3")
+	c := mustID(t, "5f000000000000000000dead")
+
+	tests := []struct {
+		name string
+		min  int
+		ids  []primitive.ObjectID
+		want int
+	}{
+		{"already distinct", 4, []primitive.ObjectID{a, c}, 4},
+		{"widened past a shared suffix", 4, []primitive.ObjectID{a, b}, 6},
+		{"after an ambiguous short id", 5, []primitive.ObjectID{a, b}, 6},
+		{"single id", 6, []primitive.ObjectID{c}, 6},
+		{"same id twice", 4, []primitive.ObjectID{a, a}, 24},
+	}
+
+	for _, tt := range tests {
+		if got := distinctLen(tt.min, tt.ids); got != tt.want {
+			t.Errorf("%s: distinctLen = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWordWrap(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		width int
+		want  []string
+	}{
+		{"fits", "buy milk", 10, []string{"buy milk"}},
+		{"exactly the width", "buy milk", 8, []string{"buy milk"}},
+		{"one past the width", "buy milk", 7, []string{"buy", "milk"}},
+		{"several lines", "call the bank about the card", 10, []string{"call the", "bank about", "the card"}},
+		{"whitespace collapsed", "  buy \t milk\n ", 20, []string{"buy milk"}},
+		{"word longer than the width", "supercalifragilistic", 8, []string{"supercal", "ifragili", "stic"}},
+		{"long word after a short one", "a abcdefghij b", 4, []string{"a", "abcd", "efgh", "ij b"}},
+		{"word as long as the width", "abcd efgh", 4, []string{"abcd", "efgh"}},
+		{"multibyte characters", "héllo wörld", 5, []string{"héllo", "wörld"}},
+		{"empty", "", 10, []string{""}},
+		{"width below one", "ab", 0, []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		if got := wordWrap(tt.s, tt.width); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: wordWrap(%q, %d) = %q, want %q", tt.name, tt.s, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestTextMatch(t *testing.T) {
+	tests := []struct {
+		term    string
+		asRegex bool
+		want    string
+		wantErr bool
+	}{
+		{"milk", false, "milk", false},
+		{"v1.2", false, `v1\.2`, false},
+		{"a*b", false, `a\*b`, false},
+		{"(draft)", false, `\(draft\)`, false},
+		{"[x] $5 ^up? {1} a|b \\", false, `\[x\] \$5 \^up\? \{1\} a\|b \\`, false},
+		{"v1.2", true, "v1.2", false},
+		{"^fix (bug|typo)$", true, "^fix (bug|typo)$", false},
+		{"a(b", true, "", true},
+		{"[unclosed", true, "", true},
+		{"a(b", false, `a\(b`, false},
+	}
+
+	for _, tt := range tests {
+		got, err := textMatch(tt.term, tt.asRegex)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("textMatch(%q, %v) succeeded, want an error", tt.term, tt.asRegex)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("textMatch(%q, %v) failed: %v", tt.term, tt.asRegex, err)
+			continue
+		}
+
+		if got.Pattern != tt.want || got.Options != "i" {
+			t.Errorf("textMatch(%q, %v) = /%s/%s, want /%s/i", tt.term, tt.asRegex, got.Pattern, got.Options, tt.want)
+		}
+	}
+}
+
+// listingContext returns the context of a listing given args
+func listingContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+
+	app := &cli.App{Flags: listingFlags()}
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range app.Flags {
+		f.Apply(set)
+	}
+
+	err := set.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cli.NewContext(app, set, nil)
+}
+
+// keys returns the top level keys of d
+func keys(d bson.D) []string {
+	list := []string{}
+	for _, e := range d {
+		list = append(list, e.Key)
+	}
+
+	return list
+}
+
+func TestListingFilterCombination(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+		alts []string
+	}{
+		{"no flags", nil, []string{}, nil},
+		{"and by default", []string{"--project", "home", "--tag", "urgent"}, []string{"project", "tags"}, nil},
+		{"conditions on several fields stay together", []string{"--tag", "urgent", "--overdue"}, []string{"tags", "completed", "due_date"}, nil},
+		{"and on the same field", []string{"--since", "7d", "--today"}, []string{"$and"}, []string{"created_at", "created_at"}},
+		{"and on the same fields", []string{"--overdue", "--due-within", "3d"}, []string{"$and"}, []string{"completed,due_date", "completed,due_date"}},
+		{"and on the same field with --filter", []string{"--since", "7d", "--today", "--filter", `{"pinned": true}`}, []string{"$and"}, []string{"created_at", "created_at", "$and"}},
+		{"or", []string{"--or", "--project", "home", "--tag", "urgent"}, []string{"$or"}, []string{"project", "tags"}},
+		{"or keeps each condition whole", []string{"--or", "--tag", "urgent", "--overdue", "--since", "7d"}, []string{"$or"}, []string{"tags", "completed,due_date", "created_at"}},
+		{"or with one condition", []string{"--or", "--tag", "urgent"}, []string{"tags"}, nil},
+		{"or without conditions", []string{"--or"}, []string{}, nil},
+		{"--filter applies on top of or", []string{"--or", "--project", "home", "--tag", "urgent", "--filter", `{"pinned": true}`}, []string{"$or", "$and"}, []string{"project", "tags"}},
+	}
+
+	for _, tt := range tests {
+		filter, err := listingFilter(listingContext(t, tt.args...))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if got := keys(filter); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: filter on %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+
+		if tt.alts == nil {
+			continue
+		}
+
+		alts, ok := filter[0].Value.(bson.A)
+		if !ok {
+			t.Errorf("%s: $or holds %T, want bson.A", tt.name, filter[0].Value)
+			continue
+		}
+
+		var got []string
+		for _, alt := range alts {
+			got = append(got, strings.Join(keys(alt.(bson.D)), ","))
+		}
+
+		if !reflect.DeepEqual(got, tt.alts) {
+			t.Errorf("%s: $or of %v, want %v", tt.name, got, tt.alts)
+		}
+	}
+}
+
+func TestListingFilterErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"--since", "soon"},
+		{"--due-within", "2x"},
+		{"--filter", "{not json"},
+	} {
+		if _, err := listingFilter(listingContext(t, args...)); err == nil {
+			t.Errorf("listingFilter(%v) succeeded, want an error", args)
+		}
+	}
+}
+
+func TestOverdueAt(t *testing.T) {
+	at := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	before := at.Add(-time.Second)
+	exactly := at
+	after := at.Add(time.Second)
+
+	tests := []struct {
+		name string
+		task *Task
+		want bool
+	}{
+		{"no due date", &Task{}, false},
+		{"no due date, completed", &Task{Completed: true}, false},
+		{"due before", &Task{DueDate: &before}, true},
+		{"due before, completed", &Task{DueDate: &before, Completed: true}, false},
+		{"due exactly then", &Task{DueDate: &exactly}, false},
+		{"due after", &Task{DueDate: &after}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.task.overdueAt(at); got != tt.want {
+			t.Errorf("%s: overdueAt = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// checkNow fails unless v is a time between from and to
+func checkNow(t *testing.T, v interface{}, from, to time.Time) {
+	t.Helper()
+
+	at, ok := v.(time.Time)
+	if !ok {
+		t.Fatalf("compared with %T, want time.Time", v)
+	}
+
+	if at.Before(from) || at.After(to) {
+		t.Errorf("compared with %v, want the current time", at)
+	}
+}
+
+func TestOverdueFilter(t *testing.T) {
+	from := time.Now()
+	filter := overdueFilter()
+	to := time.Now()
+
+	if len(filter) != 2 {
+		t.Fatalf("overdueFilter has %d conditions, want 2", len(filter))
+	}
+
+	want := primitive.E{Key: "completed", Value: false}
+	if !reflect.DeepEqual(filter[0], want) {
+		t.Errorf("overdueFilter()[0] = %v, want %v", filter[0], want)
+	}
+
+	// $lt leaves out the tasks due exactly now, as overdueAt does, and
+	// the tasks without a due date, which a query never matches with $lt
+	due, ok := filter[1].Value.(bson.D)
+	if filter[1].Key != "due_date" || !ok || len(due) != 1 || due[0].Key != "$lt" {
+		t.Fatalf("overdueFilter()[1] = %v, want due_date $lt now", filter[1])
+	}
+
+	checkNow(t, due[0].Value, from, to)
+}
+
+func TestOverdueExpr(t *testing.T) {
+	from := time.Now()
+	expr := overdueExpr()
+	to := time.Now()
+
+	if len(expr) != 1 || expr[0].Key != "$and" {
+		t.Fatalf("overdueExpr() = %v, want an $and", expr)
+	}
+
+	conds, ok := expr[0].Value.(bson.A)
+	if !ok || len(conds) != 3 {
+		t.Fatalf("overdueExpr has %v, want 3 conditions", expr[0].Value)
+	}
+
+	tests := []struct {
+		op   string
+		args bson.A
+	}{
+		{"$eq", bson.A{"$completed", false}},
+		// a missing due date would otherwise sort before now
+		{"$ifNull", bson.A{"$due_date", false}},
+		{"$lt", bson.A{"$due_date"}},
+	}
+
+	for i, tt := range tests {
+		cond, ok := conds[i].(bson.D)
+		if !ok || len(cond) != 1 || cond[0].Key != tt.op {
+			t.Errorf("condition %d = %v, want %s", i, conds[i], tt.op)
+			continue
+		}
+
+		args, ok := cond[0].Value.(bson.A)
+		if !ok || len(args) < len(tt.args) || !reflect.DeepEqual(args[:len(tt.args)], tt.args) {
+			t.Errorf("condition %d = %v, want %s of %v", i, cond, tt.op, tt.args)
+			continue
+		}
+
+		if tt.op == "$lt" {
+			if len(args) != 2 {
+				t.Errorf("condition %d = %v, want $lt of $due_date and now", i, cond)
+				continue
+			}
+
+			checkNow(t, args[1], from, to)
+		}
+	}
+}
+
+// fakeCursor yields texts as tasks, failing to decode the one at failAt
+// (from 1) and ending with err. It counts how often it is closed.
+type fakeCursor struct {
+	texts  []string
+	failAt int
+	err    error
+	pos    int
+	closed int
+}
+
+func (f *fakeCursor) Next(context.Context) bool {
+	if f.closed > 0 || f.pos == len(f.texts) {
+		return false
+	}
+
+	f.pos++
+	return true
+}
+
+func (f *fakeCursor) Decode(v interface{}) error {
+	if f.pos == f.failAt {
+		return errors.New("cannot decode")
+	}
+
+	v.(*Task).Text = f.texts[f.pos-1]
+	return nil
+}
+
+func (f *fakeCursor) Err() error {
+	return f.err
+}
+
+func (f *fakeCursor) Close(context.Context) error {
+	f.closed++
+	return nil
+}
+
+func TestDecodeTasks(t *testing.T) {
+	tests := []struct {
+		name    string
+		cur     *fakeCursor
+		want    []string
+		wantErr bool
+	}{
+		{"decode error", &fakeCursor{texts: []string{"a", "b", "c"}, failAt: 2}, []string{"a"}, true},
+		{"decode error on the first task", &fakeCursor{texts: []string{"a"}, failAt: 1}, nil, true},
+		{"exhausted", &fakeCursor{texts: []string{"a", "b"}}, []string{"a", "b"}, false},
+		{"empty", &fakeCursor{}, nil, false},
+		{"cursor error", &fakeCursor{texts: []string{"a"}, err: errors.New("connection lost")}, []string{"a"}, true},
+	}
+
+	for _, tt := range tests {
+		tasks, err := decodeTasks(tt.cur)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: decodeTasks error = %v, want an error: %v", tt.name, err, tt.wantErr)
+		}
+
+		var got []string
+		for _, task := range tasks {
+			got = append(got, task.Text)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decodeTasks = %v, want %v", tt.name, got, tt.want)
+		}
+
+		if tt.cur.closed != 1 {
+			t.Errorf("%s: cursor closed %d times, want once", tt.name, tt.cur.closed)
+		}
+	}
+}
+
+func TestInsertWithRetry(t *testing.T) {
+	delay := insertRetryDelay
+	insertRetryDelay = 0
+	defer func() { insertRetryDelay = delay }()
+
+	network := mongo.CommandError{Labels: []string{"NetworkError"}}
+	transaction := mongo.CommandError{Labels: []string{"TransientTransactionError"}}
+	duplicate := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}
+	denied := mongo.CommandError{Code: 13, Message: "not authorized"}
+
+	tests := []struct {
+		name     string
+		errs     []error
+		want     error
+		attempts int
+	}{
+		{"success", []error{nil}, nil, 1},
+		{"transient failure then success", []error{network, nil}, nil, 2},
+		{"transaction error then success", []error{transaction, transaction, nil}, nil, 3},
+		{"duplicate key after a lost reply", []error{network, duplicate}, nil, 2},
+		{"duplicate key on the first attempt", []error{duplicate}, duplicate, 1},
+		{"lasting failure", []error{network, network, network, nil}, network, maxInsertAttempts},
+		{"permanent failure", []error{denied, nil}, denied, 1},
+		{"permanent failure after a retry", []error{network, denied, nil}, denied, 2},
+	}
+
+	for _, tt := range tests {
+		attempts := 0
+		err := insertWithRetry(func() error {
+			attempts++
+			return tt.errs[attempts-1]
+		})
+
+		if !reflect.DeepEqual(err, tt.want) {
+			t.Errorf("%s: insertWithRetry = %v, want %v", tt.name, err, tt.want)
+		}
+
+		if attempts != tt.attempts {
+			t.Errorf("%s: %d attempts, want %d", tt.name, attempts, tt.attempts)
+		}
+	}
+}
+
+// fakeStore is a taskStore over tasks. races is the number of updates lost
+// to another client changing the task first.
+type fakeStore struct {
+	tasks []*Task
+	races int
+}
+
+// matches reports whether t matches filter, on the fields completeTaskIn
+// queries
+func (f *fakeStore) matches(t *Task, filter bson.D) bool {
+	for _, e := range filter {
+		var ok bool
+		switch e.Key {
+		case "_id":
+			ok = t.ID == e.Value
+		case "completed":
+			ok = t.Completed == e.Value
+		case "updated_at":
+			ok = t.UpdatedAt.Equal(e.Value.(time.Time))
+		default:
+			panic("fakeStore can't match on " + e.Key)
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f *fakeStore) findOne(filter bson.D) (*Task, error) {
+	for _, t := range f.tasks {
+		if f.matches(t, filter) {
+			c := *t
+			return &c, nil
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (f *fakeStore) count(filter bson.D) (int64, error) {
+	var n int64
+	for _, t := range f.tasks {
+		if f.matches(t, filter) {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (f *fakeStore) findOneAndUpdate(filter, update bson.D) (*Task, error) {
+	if f.races > 0 {
+		f.races--
+		for _, t := range f.tasks {
+			t.UpdatedAt = t.UpdatedAt.Add(time.Second)
+		}
+	}
+
+	for _, t := range f.tasks {
+		if !f.matches(t, filter) {
+			continue
+		}
+
+		before := *t
+		for _, op := range update {
+			for _, e := range op.Value.(bson.D) {
+				switch {
+				case op.Key == "$unset" && e.Key == "status":
+					t.Status = ""
+				case e.Key == "completed":
+					t.Completed = e.Value.(bool)
+				case e.Key == "completed_at":
+					at := e.Value.(time.Time)
+					t.CompletedAt = &at
+				case e.Key == "updated_at":
+					t.UpdatedAt = e.Value.(time.Time)
+				case e.Key == "time_spent":
+					t.TimeSpent = e.Value.(time.Duration)
+				default:
+					panic("fakeStore can't update " + op.Key + " " + e.Key)
+				}
+			}
+		}
+
+		return &before, nil
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func TestCompleteTaskIn(t *testing.T) {
+	pendingID := mustID(t, "5f0000000000000000000001")
+	doneID := mustID(t, "5f0000000000000000000002")
+	unknownID := mustID(t, "5f0000000000000000000003")
+	at := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		id      primitive.ObjectID
+		races   int
+		want    error
+		changed bool
+	}{
+		{"pending", pendingID, 0, nil, true},
+		{"pending, modified while completing", pendingID, maxConflictRetries - 1, nil, true},
+		{"pending, modified on every attempt", pendingID, maxConflictRetries, errConflict, false},
+		{"already completed", doneID, 0, errAlreadyCompleted, false},
+		{"unknown id", unknownID, 0, mongo.ErrNoDocuments, false},
+	}
+
+	for _, tt := range tests {
+		pending := &Task{ID: pendingID, Text: "pending", Status: statusDoing}
+		done := &Task{ID: doneID, Text: "done", Completed: true}
+		store := &fakeStore{tasks: []*Task{pending, done}, races: tt.races}
+
+		filter := bson.D{primitive.E{Key: "_id", Value: tt.id}}
+		before, err := completeTaskIn(store, filter, at, time.Hour)
+		if err != tt.want {
+			t.Errorf("%s: completeTaskIn error = %v, want %v", tt.name, err, tt.want)
+		}
+
+		if err == nil && (before.Completed || before.Text != "pending") {
+			t.Errorf("%s: completeTaskIn returned %+v, want the pending task before the update", tt.name, before)
+		}
+
+		if got := pending.Completed; got != tt.changed {
+			t.Errorf("%s: pending task completed = %v, want %v", tt.name, got, tt.changed)
+		}
+
+		if !tt.changed {
+			continue
+		}
+
+		if pending.CompletedAt == nil || !pending.CompletedAt.Equal(at) || pending.TimeSpent != time.Hour || pending.Status != "" {
+			t.Errorf("%s: completed task = %+v, want completed at %v after an hour with no status", tt.name, pending, at)
+		}
+	}
+}
+
+func TestAllOf(t *testing.T) {
+	a := bson.D{primitive.E{Key: "completed", Value: false}}
+	b := bson.D{primitive.E{Key: "tags", Value: "urgent"}}
+	c := bson.D{
+		primitive.E{Key: "completed", Value: false},
+		primitive.E{Key: "due_date", Value: bson.D{primitive.E{Key: "$ne", Value: nil}}},
+	}
+
+	tests := []struct {
+		name  string
+		conds []bson.D
+		want  bson.D
+	}{
+		{"none", nil, nil},
+		{"empty", []bson.D{{}, {}}, nil},
+		{"one", []bson.D{a}, a},
+		{"distinct keys", []bson.D{a, b}, append(append(bson.D{}, a...), b...)},
+		{"shared key", []bson.D{a, b, c}, bson.D{primitive.E{Key: "$and", Value: bson.A{a, b, c}}}},
+		{"shared key, empty left out", []bson.D{a, {}, c}, bson.D{primitive.E{Key: "$and", Value: bson.A{a, c}}}},
+	}
+
+	for _, tt := range tests {
+		if got := allOf(tt.conds...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: allOf = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// migration fills in a field that older tasks lack. Tasks matching filter
+// are updated with update.
+type migration struct {
+	filter bson.D
+	update bson.D
+}
+
+func missingField(field string) bson.D {
+	return bson.D{primitive.E{Key: field, Value: bson.D{primitive.E{Key: "$exists", Value: false}}}}
+}
+
+func setField(field string, value interface{}) bson.D {
+	return bson.D{primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: field, Value: value}}}}
+}
+
+var migrations = []migration{
+	{missingField("completed"), setField("completed", false)},
+	{missingField("priority"), setField("priority", minPriority)},
+	// null matches tags that are missing as well as tags stored as null,
+	// which queued adds without tags used to be replayed with
+	{bson.D{primitive.E{Key: "tags", Value: nil}}, setField("tags", bson.A{})},
+	{
+		// status only describes pending tasks, see Task.Status
+		bson.D{
+			primitive.E{Key: "completed", Value: true},
+			primitive.E{Key: "status", Value: bson.D{primitive.E{Key: "$exists", Value: true}}},
+		},
+		bson.D{primitive.E{Key: "$unset", Value: bson.D{primitive.E{Key: "status", Value: ""}}}},
+	},
+}
+
+// migrate brings tasks written by older versions of tasker up to date, so
+// that sorts and filters treat them like new ones. It returns the number of
+// tasks that needed changes.
+func migrate() (int64, error) {
+	outdated := bson.A{missingField("order"), missingField("seq")}
+	for _, m := range migrations {
+		outdated = append(outdated, m.filter)
+	}
+
+	n, err := collection.CountDocuments(ctx, bson.D{primitive.E{Key: "$or", Value: outdated}})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range migrations {
+		_, err := collection.UpdateMany(ctx, m.filter, m.update)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// order and seq need a value computed for each task
+	err = backfillOrder()
+	if err != nil {
+		return 0, err
+	}
+
+	err = backfillSeq()
+	if err != nil {
+		return 0, err
+	}
+
+	err = ensureSeqIndex(collection)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot create the unique index on task numbers, are some numbers used twice? %v", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// naturalSort sorts listings by text client side, comparing the numbers in
+// texts by their value so that "task2" comes before "task10"
+var naturalSort bool
+
+// sortNatural sorts tasks by their text in natural order, keeping pinned
+// tasks first
+func sortNatural(tasks []*Task) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		if tasks[i].Pinned != tasks[j].Pinned {
+			return tasks[i].Pinned
+		}
+
+		return naturalLess(tasks[i].Text, tasks[j].Text)
+	})
+}
+
+// naturalLess reports whether a sorts before b, ignoring case. Runs of
+// digits are compared as numbers, everything else character by character.
+func naturalLess(a, b string) bool {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+
+	// numbers that only differ in leading zeros decide the order only if
+	// the texts are otherwise the same, fewer zeros first
+	tie := 0
+
+	i, j := 0, 0
+	for i < len(ra) && j < len(rb) {
+		if unicode.IsDigit(ra[i]) && unicode.IsDigit(rb[j]) {
+			si, sj := i, j
+			for i < len(ra) && unicode.IsDigit(ra[i]) {
+				i++
+			}
+			for j < len(rb) && unicode.IsDigit(rb[j]) {
+				j++
+			}
+
+			na := strings.TrimLeft(string(ra[si:i]), "0")
+			nb := strings.TrimLeft(string(rb[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+
+			if tie == 0 {
+				tie = (i - si) - (j - sj)
+			}
+
+			continue
+		}
+
+		if ra[i] != rb[j] {
+			return ra[i] < rb[j]
+		}
+
+		i++
+		j++
+	}
+
+	if len(ra)-i != len(rb)-j {
+		return len(ra)-i < len(rb)-j
+	}
+
+	return tie < 0
+}
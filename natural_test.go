@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"task2", "task10", true},
+		{"task10", "task2", false},
+		{"task2", "task2", false},
+		{"Task2", "task10", true}, // case is ignored
+		{"task", "task1", true},   // a prefix comes first
+		{"a10b2", "a10b10", true}, // every number is compared
+		{"a10b", "a9c", false},
+		{"v1.2.10", "v1.2.9", false},
+		{"007", "7", false}, // equal numbers, fewer zeros first
+		{"7", "007", true},
+		{"07a", "7b", true}, // zeros only break a tie of the whole text
+		{"x", "1", false},   // digits sort before letters
+		{"", "a", true},
+		{"a", "", false},
+		{"item 3 of 12", "item 3 of 4", false},
+	}
+
+	for _, tt := range tests {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSortNatural(t *testing.T) {
+	tasks := []*Task{
+		{Text: "task10"},
+		{Text: "task2"},
+		{Text: "task1", Pinned: true},
+		{Text: "Task3"},
+		{Text: "task20", Pinned: true},
+	}
+
+	sortNatural(tasks)
+
+	var got []string
+	for _, task := range tasks {
+		got = append(got, task.Text)
+	}
+
+	want := []string{"task1", "task20", "task2", "Task3", "task10"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sortNatural = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+)
+
+// paged pages listings that don't fit on the terminal, see startPager
+var paged bool
+
+// pageSize is the number of lines of a page, 0 for the height of the
+// terminal
+var pageSize int
+
+// defaultHeight is assumed when the height of the terminal can't be found
+const defaultHeight = 24
+
+// pagerPrompt is shown below each page by the built-in pager
+const pagerPrompt = "-- more -- (space: next page, enter: next line, q: quit)"
+
+// capture holds what a listing prints while it is being paged
+type capture struct {
+	stdout *os.File
+	w      *os.File
+	buf    bytes.Buffer
+	done   chan struct{}
+}
+
+var captured *capture
+
+// startPager captures what the listing prints from now on, for flushPager
+// to page once it is complete. Only text listings on a terminal are paged.
+func startPager() {
+	if !paged || captured != nil || jsonOutput || tsvOutput || compactOutput || !isTerminal(os.Stdout) {
+		return
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		logDebug("cannot page the listing", "error", err.Error())
+		return
+	}
+
+	c := &capture{stdout: os.Stdout, w: w, done: make(chan struct{})}
+	go func() {
+		_, _ = io.Copy(&c.buf, r)
+		r.Close()
+		close(c.done)
+	}()
+
+	captured = c
+	os.Stdout = w
+}
+
+// flushPager prints the captured listing, through $PAGER or the built-in
+// pager if it is longer than a page
+func flushPager() {
+	if captured == nil {
+		return
+	}
+
+	c := captured
+	captured = nil
+
+	c.w.Close()
+	<-c.done
+	os.Stdout = c.stdout
+
+	out := c.buf.Bytes()
+	height := pageSize
+	if height == 0 {
+		height = terminalHeight()
+	}
+
+	if bytes.Count(out, []byte("\n")) < height {
+		_, _ = os.Stdout.Write(out)
+		return
+	}
+
+	if p := strings.Fields(os.Getenv("PAGER")); len(p) > 0 {
+		cmd := exec.Command(p[0], p[1:]...)
+		cmd.Stdin = bytes.NewReader(out)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		err := cmd.Run()
+		if err == nil {
+			return
+		}
+
+		logWarn("cannot run $PAGER, using the built-in pager", "error", err.Error())
+	}
+
+	pageOut(out, height)
+}
+
+// pageOut prints out a page of height lines at a time, waiting for a key
+// after each page
+func pageOut(out []byte, height int) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		_, _ = os.Stdout.Write(out)
+		return
+	}
+	defer tty.Close()
+
+	restore := readKeys(tty)
+	defer restore()
+
+	// stop leaves the terminal echoing again when interrupted mid-page
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	defer signal.Stop(stop)
+	go func() {
+		if _, ok := <-stop; ok {
+			restore()
+			exit(130)
+		}
+	}()
+
+	lines := strings.SplitAfter(strings.TrimSuffix(string(out), "\n"), "\n")
+
+	// a line is kept for the prompt
+	step := height - 1
+	if step < 1 {
+		step = 1
+	}
+
+	shown := 0
+	for {
+		end := shown + step
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		os.Stdout.WriteString(strings.TrimSuffix(strings.Join(lines[shown:end], ""), "\n"))
+		shown = end
+		if shown == len(lines) {
+			os.Stdout.WriteString("\n")
+			return
+		}
+
+		// the prompt takes the line below the page and is cleared again
+		// once a key is pressed, for the next line to take its place
+		os.Stdout.WriteString("\n" + pagerPrompt)
+		key := make([]byte, 1)
+		_, err := tty.Read(key)
+		os.Stdout.WriteString("\r\033[K")
+
+		if err != nil || key[0] == 'q' || key[0] == 'Q' {
+			return
+		}
+
+		step = height - 1
+		if key[0] == '\n' || key[0] == '\r' {
+			step = 1
+		}
+	}
+}
+
+// readKeys makes tty hand over each key as it is pressed, without echoing
+// it, and returns the function that restores it. Without stty, keys are
+// only read once enter is pressed.
+func readKeys(tty *os.File) func() {
+	saved, err := ttyStty(tty, "-g")
+	if err != nil {
+		return func() {}
+	}
+
+	_, err = ttyStty(tty, "cbreak", "-echo")
+	if err != nil {
+		return func() {}
+	}
+
+	return func() {
+		_, _ = ttyStty(tty, strings.TrimSpace(saved))
+	}
+}
+
+// ttyStty runs stty on tty rather than stdin, which may be redirected
+func ttyStty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// terminalHeight returns the number of lines of the terminal, from stty or
+// else $LINES
+func terminalHeight() int {
+	if rows, _ := ttySize(); rows > 0 {
+		return rows
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 0 {
+		return n
+	}
+
+	return defaultHeight
+}
+
+// ttySize returns the lines and columns of the terminal according to stty,
+// or zeros if there is no terminal. $LINES and $COLUMNS are set by shells
+// but rarely exported, so they are only a fallback.
+func ttySize() (int, int) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return 0, 0
+	}
+	defer tty.Close()
+
+	// stty size prints the rows and then the columns
+	size, err := ttyStty(tty, "size")
+	if err != nil {
+		return 0, 0
+	}
+
+	f := strings.Fields(size)
+	if len(f) != 2 {
+		return 0, 0
+	}
+
+	rows, err := strconv.Atoi(f[0])
+	if err != nil || rows < 0 {
+		return 0, 0
+	}
+
+	cols, err := strconv.Atoi(f[1])
+	if err != nil || cols < 0 {
+		return 0, 0
+	}
+
+	return rows, cols
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"text/template"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// promptTimeout replaces connectTimeout for the prompt command, which runs
+// every time the shell prompt is drawn
+const promptTimeout = 500 * time.Millisecond
+
+const defaultPromptFormat = "[{{.Pending}}!/{{.Overdue}}⏰]"
+
+// promptCounts are the values available to the --format template of the
+// prompt command
+type promptCounts struct {
+	Pending int64 `bson:"pending"`
+	Overdue int64 `bson:"overdue"`
+}
+
+// countPending counts the pending and overdue tasks in a single query
+func countPending() (*promptCounts, error) {
+	pipeline := mongo.Pipeline{
+		bson.D{primitive.E{Key: "$match", Value: bson.D{
+			primitive.E{Key: "completed", Value: false},
+		}}},
+		bson.D{primitive.E{Key: "$group", Value: bson.D{
+			primitive.E{Key: "_id", Value: nil},
+			primitive.E{Key: "pending", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}},
+			primitive.E{Key: "overdue", Value: bson.D{primitive.E{Key: "$sum", Value: bson.D{
+				primitive.E{Key: "$cond", Value: bson.A{overdueExpr(), 1, 0}},
+			}}}},
+		}}},
+	}
+
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	counts := &promptCounts{}
+	if cur.Next(ctx) {
+		err = cur.Decode(counts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return counts, cur.Err()
+}
+
+// printPrompt prints the task counts for a shell prompt. It prints nothing
+// at all if anything goes wrong, so that a broken database or template
+// doesn't clutter every prompt.
+func printPrompt(format string) {
+	tmpl, err := template.New("prompt").Parse(format)
+	if err != nil {
+		return
+	}
+
+	counts, err := countPending()
+	if err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, counts)
+	if err != nil {
+		return
+	}
+
+	buf.WriteTo(os.Stdout)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// offline is set when operations are recorded to the local queue instead of
+// being sent to the database
+var offline bool
+
+// queueable holds the commands (and their aliases) that can run offline
+var queueable = map[string]bool{
+	"add":  true,
+	"a":    true,
+	"done": true,
+	"d":    true,
+	"rm":   true,
+}
+
+// queuedOp is an operation recorded while offline. Added tasks carry their
+// client generated id so replaying an add twice cannot create a duplicate.
+type queuedOp struct {
+	Op       string        `json:"op"`
+	Task     *Task         `json:"task,omitempty"`
+	Text     string        `json:"text,omitempty"`
+	At       *time.Time    `json:"at,omitempty"`
+	Spent    time.Duration `json:"spent,omitempty"`
+	QueuedAt time.Time     `json:"queued_at"`
+}
+
+func queuePath() (string, error) {
+	if path := os.Getenv("TASKER_QUEUE"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "tasker", "queue.json"), nil
+}
+
+func readQueue() ([]*queuedOp, error) {
+	var ops []*queuedOp
+
+	path, err := queuePath()
+	if err != nil {
+		return ops, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ops, nil
+		}
+
+		return ops, err
+	}
+
+	err = json.Unmarshal(b, &ops)
+	return ops, err
+}
+
+func writeQueue(ops []*queuedOp) error {
+	path, err := queuePath()
+	if err != nil {
+		return err
+	}
+
+	if len(ops) == 0 {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(ops, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+func queueOp(op *queuedOp) error {
+	ops, err := readQueue()
+	if err != nil {
+		return err
+	}
+
+	op.QueuedAt = now()
+	ops = append(ops, op)
+
+	err = writeQueue(ops)
+	if err != nil {
+		return err
+	}
+
+	if !quiet {
+		fmt.Printf("Queued %s until the next sync (%d pending)\n", op, len(ops))
+	}
+
+	return nil
+}
+
+func (op *queuedOp) String() string {
+	text := op.Text
+	if op.Task != nil {
+		text = op.Task.Text
+	}
+
+	return fmt.Sprintf("%s '%s'", op.Op, text)
+}
+
+// syncQueue replays the queued operations in the order they were recorded.
+// Operations that no longer apply, such as completing a task that has since
+// been deleted, are reported as conflicts and dropped. If an operation fails
+// for any other reason, it and everything after it stay queued.
+func syncQueue() error {
+	ops, err := readQueue()
+	if err != nil {
+		return err
+	}
+
+	if len(ops) == 0 {
+		if !quiet {
+			fmt.Println("Nothing to sync")
+		}
+
+		return nil
+	}
+
+	var applied int64
+	var conflicts []string
+
+	for i, op := range ops {
+		conflict, err := replayOp(op)
+		if err != nil {
+			if werr := writeQueue(ops[i:]); werr != nil {
+				return werr
+			}
+
+			return fmt.Errorf("Sync stopped at %s: %v", op, err)
+		}
+
+		if conflict != "" {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s", op, conflict))
+			continue
+		}
+
+		applied++
+	}
+
+	err = writeQueue(nil)
+	if err != nil {
+		return err
+	}
+
+	printSummary("operation", "synced", applied, int64(len(conflicts)))
+	for _, c := range conflicts {
+		fmt.Println("  conflict: " + c)
+	}
+
+	return nil
+}
+
+// replayOp applies a single queued operation. It returns a description of
+// the conflict if the operation can no longer be applied as recorded.
+func replayOp(op *queuedOp) (string, error) {
+	switch op.Op {
+	case "add":
+		// the queue keeps tasks as JSON, which leaves out empty tags
+		if op.Task.Tags == nil {
+			op.Task.Tags = []string{}
+		}
+
+		err := createTask(op.Task)
+		if isDuplicateKey(err) {
+			// already added by an earlier, interrupted sync
+			return "", nil
+		}
+
+		return "", err
+	case "done":
+		at := op.QueuedAt
+		if op.At != nil {
+			at = *op.At
+		}
+
+		filter := bson.D{primitive.E{Key: "text", Value: op.Text}}
+		_, err := completeTask(filter, at, op.Spent)
+		if err == mongo.ErrNoDocuments {
+			return "no task with this text exists anymore", nil
+		}
+		if err == errAlreadyCompleted {
+			return "the task is already completed", nil
+		}
+
+		return "", err
+	case "rm":
+		err := deleteTask(op.Text)
+		if err == errNotDeleted {
+			return "no task with this text exists anymore", nil
+		}
+
+		return "", err
+	default:
+		return fmt.Sprintf("unknown operation %q", op.Op), nil
+	}
+}
+
+func isDuplicateKey(err error) bool {
+	if ce, ok := err.(mongo.CommandError); ok {
+		return ce.Code == 11000
+	}
+
+	we, ok := err.(mongo.WriteException)
+	if !ok {
+		return false
+	}
+
+	for _, e := range we.WriteErrors {
+		if e.Code == 11000 {
+			return true
+		}
+	}
+
+	return false
+}
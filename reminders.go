@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// checkReminders notifies about the pending tasks that have become overdue
+// since the last check. Each task is only reported once: it is marked as
+// notified before the notification is sent, and unmarked again if sending
+// fails so that the next check retries it. Setting a new due date clears the
+// mark.
+func checkReminders() error {
+	filter := append(overdueFilter(),
+		primitive.E{Key: "notified", Value: bson.D{primitive.E{Key: "$ne", Value: true}}},
+	)
+
+	tasks, err := filterTasks(filter)
+	if err == mongo.ErrNoDocuments {
+		// nothing has become overdue since the last check
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var sent, failed int64
+	for _, t := range tasks {
+		claimed, err := setNotified(t.ID, true)
+		if err != nil {
+			return err
+		}
+
+		if !claimed {
+			// another check got to it first
+			continue
+		}
+
+		err = notify(t)
+		if err != nil {
+			logWarn("notification failed", "task", t.Text, "error", err.Error())
+			failed++
+
+			_, err = setNotified(t.ID, false)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		sent++
+	}
+
+	if config.NotifyCommand != "" {
+		printSummary("reminder", "sent", sent, failed)
+	}
+
+	return nil
+}
+
+// setNotified changes the notified mark of a task and reports whether it
+// was changed, i.e. whether it wasn't already set that way
+func setNotified(id primitive.ObjectID, notified bool) (bool, error) {
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: id},
+		primitive.E{Key: "notified", Value: bson.D{primitive.E{Key: "$ne", Value: notified}}},
+	}
+	update := bson.D{primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "notified", Value: notified}}}}
+
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount == 1, nil
+}
+
+// notify runs the configured notify_command with the text of t as its last
+// argument, or prints the reminder if no command is configured
+func notify(t *Task) error {
+	if config.NotifyCommand == "" {
+		fmt.Printf("Overdue since %s: %s\n", t.DueDate.Local().Format("2006-01-02 15:04"), t.Text)
+		return nil
+	}
+
+	args := append(strings.Fields(config.NotifyCommand), t.Text)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// reportBarWidth is the length of the bar of the busiest day in a report
+const reportBarWidth = 40
+
+// dayCount is the number of tasks completed on a day
+type dayCount struct {
+	Date  string `bson:"_id" json:"date"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// completedPerDay counts the tasks completed on each of the given number
+// of days up to and including today, in the local time zone. Days without
+// completions are included with a count of zero.
+func completedPerDay(days int) ([]dayCount, error) {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, time.Local)
+
+	// MongoDB doesn't know our zone by name, so days are split at the
+	// current UTC offset
+	_, offset := now.Zone()
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	tz := fmt.Sprintf("%s%02d:%02d", sign, offset/3600, offset%3600/60)
+
+	pipeline := bson.A{
+		bson.D{primitive.E{Key: "$match", Value: bson.D{
+			primitive.E{Key: "completed", Value: true},
+			primitive.E{Key: "completed_at", Value: bson.D{primitive.E{Key: "$gte", Value: start}}},
+		}}},
+		bson.D{primitive.E{Key: "$group", Value: bson.D{
+			primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$dateToString", Value: bson.D{
+				primitive.E{Key: "format", Value: "%Y-%m-%d"},
+				primitive.E{Key: "date", Value: "$completed_at"},
+				primitive.E{Key: "timezone", Value: tz},
+			}}}},
+			primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}},
+		}}},
+	}
+
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	counts := make(map[string]int64)
+	for cur.Next(ctx) {
+		var d dayCount
+		err := cur.Decode(&d)
+		if err != nil {
+			return nil, err
+		}
+
+		counts[d.Date] = d.Count
+	}
+
+	err = cur.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dayCount, days)
+	for i := range result {
+		date := start.AddDate(0, 0, i).Format("2006-01-02")
+		result[i] = dayCount{Date: date, Count: counts[date]}
+	}
+
+	return result, nil
+}
+
+// printReport prints a bar chart of the tasks completed on each day
+func printReport(days []dayCount) {
+	if jsonOutput {
+		printJSON(days)
+		return
+	}
+
+	var total, most int64
+	for _, d := range days {
+		total += d.Count
+		if d.Count > most {
+			most = d.Count
+		}
+	}
+
+	if total == 0 {
+		fmt.Printf("No tasks completed in the last %d days\n", len(days))
+		return
+	}
+
+	for _, d := range days {
+		day, _ := time.ParseInLocation("2006-01-02", d.Date, time.Local)
+		bar := strings.Repeat("█", int(d.Count*reportBarWidth/most))
+		fmt.Printf("%s %s %s %d\n", day.Format("Mon"), d.Date, bar, d.Count)
+	}
+
+	fmt.Printf("\n%s completed, %.1f a day\n", plural(total, "task"), float64(total)/float64(len(days)))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// purgesCollection records when the completed tasks of each collection were
+// last purged under the retention setting, keyed by the collection's name
+const purgesCollection = "purges"
+
+// purgeExpired deletes the completed tasks that were completed longer ago
+// than the retention setting. It does nothing unless retention is set, and
+// at most once a day for each collection however many commands run.
+func purgeExpired() error {
+	if config.Retention == "" {
+		return nil
+	}
+
+	window, err := parseWindow(config.Retention)
+	if err != nil {
+		return fmt.Errorf("Invalid retention %q, expected e.g. 30d or 8w", config.Retention)
+	}
+
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+
+	// Claim today's purge. Once it has been claimed the filter no longer
+	// matches and the upsert fails on the existing id, so concurrent
+	// commands can't both get past this.
+	claim := bson.D{
+		primitive.E{Key: "_id", Value: collection.Name()},
+		primitive.E{Key: "purged_at", Value: bson.D{primitive.E{Key: "$lt", Value: today}}},
+	}
+	update := setField("purged_at", time.Now())
+
+	_, err = database.Collection(purgesCollection).UpdateOne(ctx, claim, update, options.Update().SetUpsert(true))
+	if isDuplicateKey(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-window)
+	expired := bson.D{
+		primitive.E{Key: "completed", Value: true},
+		primitive.E{Key: "completed_at", Value: bson.D{primitive.E{Key: "$lt", Value: cutoff}}},
+	}
+
+	if verbose {
+		tasks, err := filterTasks(expired)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		for _, t := range tasks {
+			logDebug("purging expired task", "id", t.ID.Hex(), "text", t.Text, "completed_at", t.CompletedAt.Format(time.RFC3339))
+		}
+	}
+
+	n, err := deleteTasks(expired, true)
+	if err != nil {
+		return err
+	}
+
+	logDebug("purged expired tasks", "count", n, "retention", config.Retention)
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// countersCollection holds a sequence counter for each task collection in
+// the database, keyed by the name of the collection
+const countersCollection = "counters"
+
+// seqIndex is the name of the unique index on seq. It only covers tasks that
+// have a number, as tasks added before numbers existed don't until they are
+// migrated.
+const seqIndex = "seq_unique"
+
+// nextSeq returns the next sequence number of the current collection
+func nextSeq() (int64, error) {
+	return nextSeqIn(collection)
+}
+
+// nextSeqIn returns the next sequence number of coll. The counter is
+// incremented by the database, so concurrent adds never share a number.
+func nextSeqIn(coll *mongo.Collection) (int64, error) {
+	filter := bson.D{primitive.E{Key: "_id", Value: coll.Name()}}
+	update := bson.D{primitive.E{Key: "$inc", Value: bson.D{primitive.E{Key: "seq", Value: 1}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+
+	var err error
+	for i := 0; i < 2; i++ {
+		err = database.Collection(countersCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&counter)
+
+		// two first adds can both try to create the counter, the loser
+		// finds it on the second attempt
+		if !isDuplicateKey(err) {
+			break
+		}
+	}
+
+	if err == nil && counter.Seq == 1 {
+		// the first task of a collection is a good time to make sure no
+		// number is ever given twice
+		if ierr := ensureSeqIndex(coll); ierr != nil {
+			logWarn("cannot create the index on seq", "collection", coll.Name(), "error", ierr.Error())
+		}
+	}
+
+	return counter.Seq, err
+}
+
+// raiseSeq moves the counter of coll up to at least n, for tasks that were
+// written with their numbers, e.g. by restore, so that the next add doesn't
+// give one of them out again
+func raiseSeq(coll *mongo.Collection, n int64) error {
+	filter := bson.D{primitive.E{Key: "_id", Value: coll.Name()}}
+	update := bson.D{primitive.E{Key: "$max", Value: bson.D{primitive.E{Key: "seq", Value: n}}}}
+
+	_, err := database.Collection(countersCollection).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if isDuplicateKey(err) {
+		// created by a concurrent add, which $max now applies to
+		_, err = database.Collection(countersCollection).UpdateOne(ctx, filter, update)
+	}
+
+	return err
+}
+
+// ensureSeqIndex creates the unique index on seq of coll, if it doesn't
+// exist yet
+func ensureSeqIndex(coll *mongo.Collection) error {
+	model := mongo.IndexModel{
+		Keys: bson.D{primitive.E{Key: "seq", Value: 1}},
+		Options: options.Index().
+			SetName(seqIndex).
+			SetUnique(true).
+			SetPartialFilterExpression(bson.D{primitive.E{Key: "seq", Value: bson.D{
+				primitive.E{Key: "$exists", Value: true},
+			}}}),
+	}
+
+	_, err := coll.Indexes().CreateOne(ctx, model)
+	return err
+}
+
+// parseSeq parses a reference to a task by its sequence number, such as #12
+func parseSeq(ref string) (int64, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(ref[1:], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// maxSeqRange is the most tasks a range such as #3-#7 can cover, so that a
+// typo in one of the numbers can't complete a whole list
+const maxSeqRange = 100
+
+// parseSeqRange parses a range of sequence numbers such as #3-#7 or #3-7. It
+// returns false if ref isn't a range, and an error if it is one that can't
+// be used. The # is required, as plain numbers refer to the listing and a
+// text such as 2023-2024 isn't a range.
+func parseSeqRange(ref string) (int64, int64, bool, error) {
+	parts := strings.SplitN(ref, "-", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "#") {
+		return 0, 0, false, nil
+	}
+
+	start, err := strconv.ParseInt(parts[0][1:], 10, 64)
+	if err != nil {
+		return 0, 0, false, nil
+	}
+
+	end, err := strconv.ParseInt(strings.TrimPrefix(parts[1], "#"), 10, 64)
+	if err != nil {
+		return 0, 0, false, nil
+	}
+
+	switch {
+	case start <= 0:
+		return 0, 0, true, fmt.Errorf("Invalid range %s, task numbers start at 1", ref)
+	case end < start:
+		return 0, 0, true, fmt.Errorf("Invalid range %s, expected the first number to be the lower one", ref)
+	case end-start >= maxSeqRange:
+		return 0, 0, true, fmt.Errorf("The range %s covers %d tasks, at most %d can be given at once", ref, end-start+1, maxSeqRange)
+	}
+
+	return start, end, true, nil
+}
+
+// completeSeqRange completes the pending tasks numbered start to end at t.
+// It returns the tasks completed, in order, and the numbers of the range
+// that no pending task has.
+func completeSeqRange(start, end int64, t time.Time) ([]*Task, []int64, error) {
+	nums := make(bson.A, 0, end-start+1)
+	for n := start; n <= end; n++ {
+		nums = append(nums, n)
+	}
+
+	filter := bson.D{
+		primitive.E{Key: "completed", Value: false},
+		primitive.E{Key: "seq", Value: bson.D{primitive.E{Key: "$in", Value: nums}}},
+	}
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "seq", Value: 1}})
+
+	tasks, err := filterTasks(filter, opts)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, nil, err
+	}
+
+	found := make(map[int64]bool, len(tasks))
+	matched := make(bson.A, len(tasks))
+	for i, task := range tasks {
+		found[task.Seq] = true
+		matched[i] = task.Seq
+	}
+
+	var missing []int64
+	for n := start; n <= end; n++ {
+		if !found[n] {
+			missing = append(missing, n)
+		}
+	}
+
+	if len(tasks) == 0 {
+		return nil, missing, nil
+	}
+
+	// only the numbers found are completed, so a task added to the range
+	// in the meantime isn't completed without being reported
+	_, err = completeMany(bson.D{primitive.E{Key: "seq", Value: bson.D{
+		primitive.E{Key: "$in", Value: matched},
+	}}}, t)
+
+	return tasks, missing, err
+}
+
+// seqList formats sequence numbers for a message, e.g. #3, #5 and #6
+func seqList(nums []int64) string {
+	refs := make([]string, len(nums))
+	for i, n := range nums {
+		refs[i] = "#" + strconv.FormatInt(n, 10)
+	}
+
+	if len(refs) == 1 {
+		return refs[0]
+	}
+
+	return strings.Join(refs[:len(refs)-1], ", ") + " and " + refs[len(refs)-1]
+}
+
+// findBySeq returns the task with the given sequence number
+func findBySeq(n int64) (*Task, error) {
+	filter := bson.D{primitive.E{Key: "seq", Value: n}}
+
+	t := &Task{}
+	err := collection.FindOne(ctx, filter).Decode(t)
+	if err == mongo.ErrNoDocuments {
+		return nil, &noMatchError{fmt.Sprintf("There is no task #%d", n)}
+	}
+
+	return t, err
+}
+
+// backfillSeq numbers the tasks created before sequence numbers existed, in
+// the order they were created
+func backfillSeq() error {
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "created_at", Value: 1}})
+
+	tasks, err := filterTasks(missingField("seq"), opts)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, t := range tasks {
+		n, err := nextSeq()
+		if err != nil {
+			return err
+		}
+
+		_, err = collection.UpdateOne(ctx, bson.D{primitive.E{Key: "_id", Value: t.ID}}, setField("seq", n))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
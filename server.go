@@ -0,0 +1,232 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var errTaskNotFound = errors.New("Task not found")
+
+// ui is the web page served at / with --ui. It only talks to the API below.
+//
+//go:embed ui
+var ui embed.FS
+
+// newServer returns the handler behind `tasker serve`. It exposes the task
+// list as a small JSON API:
+//
+//	GET    /tasks      list all tasks
+//	POST   /tasks      add a task, e.g. {"text": "buy milk"}
+//	GET    /tasks/:id  fetch a single task
+//	PATCH  /tasks/:id  update a task, e.g. {"completed": true}
+//	DELETE /tasks/:id  delete a task
+//
+// With withUI set, a web page for the task list is served at / as well.
+func newServer(withUI bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tasks", handleTasks)
+	mux.HandleFunc("/tasks/", handleTask)
+
+	if withUI {
+		root, err := fs.Sub(ui, "ui")
+		if err != nil {
+			panic(err)
+		}
+
+		mux.Handle("/", http.FileServer(http.FS(root)))
+	}
+
+	return mux
+}
+
+func handleTasks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sort, err := sortOrder(config.Sort["all"])
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		tasks, err := getAll(true, nil, sort)
+		if err != nil && err != mongo.ErrNoDocuments {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		// Always respond with an array, even when the list is empty
+		if tasks == nil {
+			tasks = []*Task{}
+		}
+
+		writeJSON(w, http.StatusOK, tasks)
+	case http.MethodPost:
+		// a form on any web page can POST here without the browser asking
+		// first, but only with a form content type
+		if !isJSON(r) {
+			writeError(w, http.StatusUnsupportedMediaType, errors.New("Content-Type must be application/json"))
+			return
+		}
+
+		var body struct {
+			Text string `json:"text"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if body.Text == "" {
+			writeError(w, http.StatusBadRequest, errors.New("Cannot add an empty task"))
+			return
+		}
+
+		task := newTask(body.Text)
+		err = createTask(task)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, task)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+func handleTask(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(r.URL.Path, "/tasks/"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, errTaskNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		task, err := getTask(id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, task)
+	case http.MethodPatch:
+		// Pointers distinguish fields left out of the request from fields
+		// explicitly set to their zero value
+		var body struct {
+			Text      *string `json:"text"`
+			Completed *bool   `json:"completed"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		var fields bson.D
+		if body.Text != nil {
+			if *body.Text == "" {
+				writeError(w, http.StatusBadRequest, errors.New("Task text cannot be empty"))
+				return
+			}
+
+			fields = append(fields, primitive.E{Key: "text", Value: *body.Text})
+		}
+
+		// completing goes through completeTask, as done does
+		complete := body.Completed != nil && *body.Completed
+		if body.Completed != nil && !complete {
+			fields = append(fields,
+				primitive.E{Key: "completed", Value: false},
+				primitive.E{Key: "completed_at", Value: nil},
+			)
+		}
+
+		if len(fields) == 0 && !complete {
+			writeError(w, http.StatusBadRequest, errors.New("Nothing to update"))
+			return
+		}
+
+		if len(fields) > 0 {
+			_, err = updateTask(id, fields)
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+		}
+
+		if complete {
+			filter := bson.D{primitive.E{Key: "_id", Value: id}}
+			_, err = completeTask(filter, now(), 0)
+			if err == errConflict {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+
+			// completing a completed task leaves it as it is
+			if err != nil && err != errAlreadyCompleted {
+				writeStoreError(w, err)
+				return
+			}
+		}
+
+		task, err := getTask(id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, task)
+	case http.MethodDelete:
+		err := deleteTaskByID(id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PATCH, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// isJSON reports whether the body of r is declared to be JSON
+func isJSON(r *http.Request) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && t == "application/json"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeStoreError maps errors from the store functions to a response,
+// reporting a missing document as a 404
+func writeStoreError(w http.ResponseWriter, err error) {
+	if err == mongo.ErrNoDocuments {
+		writeError(w, http.StatusNotFound, errTaskNotFound)
+		return
+	}
+
+	writeError(w, http.StatusInternalServerError, err)
+}
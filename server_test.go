@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAddTaskContentType(t *testing.T) {
+	for _, ct := range []string{
+		"",
+		"application/x-www-form-urlencoded",
+		"multipart/form-data; boundary=x",
+		"text/plain",
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"text": "buy milk"}`))
+		if ct != "" {
+			r.Header.Set("Content-Type", ct)
+		}
+
+		w := httptest.NewRecorder()
+		newServer(false).ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("POST /tasks as %q = %d, want %d", ct, w.Code, http.StatusUnsupportedMediaType)
+		}
+	}
+}
+
+func TestIsJSON(t *testing.T) {
+	tests := []struct {
+		ct   string
+		want bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"Application/JSON", true},
+		{"text/plain", false},
+		{"application/jsonx", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/tasks", nil)
+		r.Header.Set("Content-Type", tt.ct)
+		if got := isJSON(r); got != tt.want {
+			t.Errorf("isJSON(%q) = %v, want %v", tt.ct, got, tt.want)
+		}
+	}
+}
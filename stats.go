@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// taskStats are the counts shown by the stats command. Created and
+// Completed cover the window the stats were asked for, while Pending and
+// Overdue always describe the task list as it is now: a task created before
+// the window that is still open is pending all the same.
+type taskStats struct {
+	Since     *time.Time `bson:"-" json:"since,omitempty"`
+	Created   int64      `bson:"created" json:"created"`
+	Completed int64      `bson:"completed" json:"completed"`
+	Pending   int64      `bson:"pending" json:"pending"`
+	Overdue   int64      `bson:"overdue" json:"overdue"`
+
+	// TimeSpent is the total time recorded with done --time on the tasks
+	// completed in the window
+	TimeSpent time.Duration `bson:"time_spent" json:"time_spent"`
+}
+
+// parseWindow parses the length of a time window such as 7d or 2w, or any
+// duration understood by time.ParseDuration, e.g. 12h
+func parseWindow(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+
+	for suffix, unit := range units {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil || n <= 0 {
+				break
+			}
+
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid window %q, expected e.g. 7d, 2w or 12h", s)
+	}
+
+	return d, nil
+}
+
+// countIf sums 1 for every document matching the aggregation expression
+func countIf(cond interface{}) bson.D {
+	return bson.D{primitive.E{Key: "$sum", Value: bson.D{
+		primitive.E{Key: "$cond", Value: bson.A{cond, 1, 0}},
+	}}}
+}
+
+// getStats counts the tasks created and completed since the given time, or
+// over all time if since is nil, in a single aggregation
+func getStats(since *time.Time) (*taskStats, error) {
+	created := interface{}(true)
+	completed := interface{}("$completed")
+	if since != nil {
+		created = bson.D{primitive.E{Key: "$gte", Value: bson.A{"$created_at", *since}}}
+		completed = bson.D{primitive.E{Key: "$and", Value: bson.A{
+			"$completed",
+			bson.D{primitive.E{Key: "$gte", Value: bson.A{"$completed_at", *since}}},
+		}}}
+	}
+
+	pending := bson.D{primitive.E{Key: "$eq", Value: bson.A{"$completed", false}}}
+
+	pipeline := mongo.Pipeline{
+		bson.D{primitive.E{Key: "$group", Value: bson.D{
+			primitive.E{Key: "_id", Value: nil},
+			primitive.E{Key: "created", Value: countIf(created)},
+			primitive.E{Key: "completed", Value: countIf(completed)},
+			primitive.E{Key: "pending", Value: countIf(pending)},
+			primitive.E{Key: "overdue", Value: countIf(overdueExpr())},
+			primitive.E{Key: "time_spent", Value: bson.D{primitive.E{Key: "$sum", Value: bson.D{
+				primitive.E{Key: "$cond", Value: bson.A{completed, bson.D{primitive.E{Key: "$ifNull", Value: bson.A{"$time_spent", 0}}}, 0}},
+			}}}},
+		}}},
+	}
+
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	stats := &taskStats{}
+	if cur.Next(ctx) {
+		err = cur.Decode(stats)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stats.Since = since
+	return stats, cur.Err()
+}
+
+func printStats(s *taskStats) {
+	if jsonOutput {
+		printJSON(s)
+		return
+	}
+
+	if s.Since != nil {
+		fmt.Printf("Since %s:\n", s.Since.Local().Format("2006-01-02 15:04"))
+	} else {
+		fmt.Println("All time:")
+	}
+
+	fmt.Printf("  Created:   %d\n", s.Created)
+	fmt.Printf("  Completed: %d\n", s.Completed)
+	fmt.Printf("  Pending:   %d (now)\n", s.Pending)
+	fmt.Printf("  Overdue:   %d (now)\n", s.Overdue)
+	if s.TimeSpent > 0 {
+		fmt.Printf("  Spent:     %s on completed tasks\n", humanizeDuration(s.TimeSpent))
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// templatesCollection holds the saved templates, next to the task list in
+// the same database
+const templatesCollection = "templates"
+
+// taskTemplate is a named set of tasks that can be added again in one go,
+// e.g. a morning routine
+type taskTemplate struct {
+	Name      string          `bson:"_id" json:"name"`
+	Tasks     []*templateTask `bson:"tasks" json:"tasks"`
+	CreatedAt time.Time       `bson:"created_at" json:"created_at"`
+}
+
+// templateTask is the part of a task kept by a template. Everything else,
+// such as the due date, belongs to a single occurrence of the task.
+type templateTask struct {
+	Text     string   `bson:"text" json:"text"`
+	Tags     []string `bson:"tags" json:"tags,omitempty"`
+	Project  string   `bson:"project,omitempty" json:"project,omitempty"`
+	Priority int      `bson:"priority,omitempty" json:"priority,omitempty"`
+}
+
+func templates() *mongo.Collection {
+	return database.Collection(templatesCollection)
+}
+
+// saveTemplate saves the pending tasks as the template called name. An
+// existing template of that name is only replaced if replace is set.
+func saveTemplate(name string, replace bool) (*taskTemplate, error) {
+	if name == "" {
+		return nil, errors.New("No template name specified")
+	}
+
+	tasks, err := getPending(nil, pendingSort)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	if len(tasks) == 0 {
+		return nil, errors.New("There are no pending tasks to save")
+	}
+
+	tmpl := &taskTemplate{Name: name, CreatedAt: now()}
+	for _, t := range tasks {
+		tmpl.Tasks = append(tmpl.Tasks, &templateTask{
+			Text:     t.Text,
+			Tags:     t.Tags,
+			Project:  t.Project,
+			Priority: t.Priority,
+		})
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: name}}
+	if !replace {
+		_, err = templates().InsertOne(ctx, tmpl)
+		if isDuplicateKey(err) {
+			return nil, fmt.Errorf("Template '%s' already exists, use --force to replace it", name)
+		}
+
+		return tmpl, err
+	}
+
+	opts := options.Replace().SetUpsert(true)
+	_, err = templates().ReplaceOne(ctx, filter, tmpl, opts)
+	return tmpl, err
+}
+
+// applyTemplate adds the tasks of the template called name as new pending
+// tasks, in the order they were saved in
+func applyTemplate(name string) ([]*Task, error) {
+	tmpl := &taskTemplate{}
+	filter := bson.D{primitive.E{Key: "_id", Value: name}}
+
+	err := templates().FindOne(ctx, filter).Decode(tmpl)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("There is no template called '%s'", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var added []*Task
+	for _, tt := range tmpl.Tasks {
+		t := newTask(tt.Text)
+		t.Project = tt.Project
+		t.Priority = tt.Priority
+		if tt.Tags != nil {
+			t.Tags = tt.Tags
+		}
+
+		err = createTask(t)
+		if err != nil {
+			return added, err
+		}
+
+		added = append(added, t)
+	}
+
+	return added, nil
+}
+
+// listTemplates returns the saved templates sorted by name
+func listTemplates() ([]*taskTemplate, error) {
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "_id", Value: 1}})
+
+	cur, err := templates().Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*taskTemplate{}
+	err = cur.All(ctx, &list)
+	return list, err
+}
+
+func printTemplates(list []*taskTemplate) {
+	if jsonOutput {
+		printJSON(list)
+		return
+	}
+
+	if len(list) == 0 {
+		if !quiet {
+			fmt.Println("No templates saved yet, create one with template save NAME")
+		}
+		return
+	}
+
+	for _, tmpl := range list {
+		fmt.Printf("%s (%s)\n", tmpl.Name, plural(int64(len(tmpl.Tasks)), "task"))
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsConfig builds the TLS settings of the connection. Servers are verified
+// against the system's certificate authorities, plus those in caFile if it
+// is given, unless insecure is set.
+func tlsConfig(caFile string, insecure bool) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: insecure}
+
+	if caFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read the CA file: %v", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No PEM encoded certificates found in %s", caFile)
+	}
+
+	cfg.RootCAs = pool
+	return cfg, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tsvOutput prints listings as tab separated values, for pasting into a
+// spreadsheet
+var tsvOutput bool
+
+// noHeader leaves out the header row, e.g. when appending to a file
+var noHeader bool
+
+// tsvColumn is a column of the tab separated output
+type tsvColumn struct {
+	name  string
+	value func(t *Task) string
+}
+
+var tsvColumns = []tsvColumn{
+	{"id", func(t *Task) string { return t.ID.Hex() }},
+	{"text", func(t *Task) string { return t.Text }},
+	{"completed", func(t *Task) string { return strconv.FormatBool(t.Completed) }},
+	{"created_at", func(t *Task) string { return tsvTime(t.CreatedAt) }},
+	{"updated_at", func(t *Task) string { return tsvTime(t.UpdatedAt) }},
+}
+
+// tsvEscaper keeps values on a single cell. Backslashes are escaped too so
+// that the escapes can be told apart from text that contains them.
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func tsvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
+
+// printTSV prints tasks as tab separated values, after a header row unless
+// noHeader is set. Only the id and the columns in shownFields are printed
+// when --fields is given.
+func printTSV(tasks []*Task) {
+	columns := tsvColumns
+	if shownFields != nil {
+		columns = nil
+		for _, col := range tsvColumns {
+			if col.name == "id" || contains(shownFields, col.name) {
+				columns = append(columns, col)
+			}
+		}
+	}
+
+	row := make([]string, len(columns))
+
+	if !noHeader {
+		for i, col := range columns {
+			row[i] = col.name
+		}
+		fmt.Println(strings.Join(row, "\t"))
+	}
+
+	for _, t := range tasks {
+		for i, col := range columns {
+			row[i] = tsvEscaper.Replace(col.value(t))
+		}
+		fmt.Println(strings.Join(row, "\t"))
+	}
+}
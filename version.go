@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/urfave/cli/v2"
+)
+
+// The build is described by these, set at build time with e.g.
+//
+//	go build -ldflags "-X main.buildVersion=1.2.0 -X main.buildCommit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%d)"
+var (
+	buildVersion = "dev"
+	buildCommit  = "unknown"
+	buildDate    = "unknown"
+)
+
+// printVersion prints the build metadata, for both --version and the
+// version command
+func printVersion(c *cli.Context) {
+	fmt.Fprintf(c.App.Writer, "tasker %s\n", buildVersion)
+	fmt.Fprintf(c.App.Writer, "commit: %s\n", buildCommit)
+	fmt.Fprintf(c.App.Writer, "built: %s\n", buildDate)
+	fmt.Fprintf(c.App.Writer, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}